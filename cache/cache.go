@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// Entry is the last message received on a topic
+type Entry struct {
+	Topic      string    `json:"topic"`
+	Payload    []byte    `json:"payload"`
+	Value      string    `json:"value"`
+	ReceivedAt time.Time `json:"received_at"`
+	ChangedAt  time.Time `json:"changed_at"`
+	FirstSeen  time.Time `json:"first_seen"`
+	Retained   bool      `json:"retained"`
+	QoS        byte      `json:"qos"`
+	Updates    uint64    `json:"updates"`
+	// Restored is set for entries loaded from a snapshot until the next update
+	Restored bool `json:"restored"`
+}
+
+// Cache stores the last message per topic, safe for concurrent use
+type Cache struct {
+	lock    sync.RWMutex
+	entries map[string]*Entry
+}
+
+func New() *Cache {
+	return &Cache{
+		entries: map[string]*Entry{},
+	}
+}
+
+// Get returns a copy of the entry for topic
+func (c *Cache) Get(topic string) (Entry, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	e, found := c.entries[topic]
+	if !found {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// Set stores payload for topic and returns the updated entry
+func (c *Cache) Set(topic string, payload []byte, retained bool, qos byte) Entry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := now()
+	e, found := c.entries[topic]
+	if !found {
+		e = &Entry{
+			Topic:     topic,
+			FirstSeen: t,
+		}
+		c.entries[topic] = e
+	}
+	if !found || e.Value != string(payload) {
+		e.ChangedAt = t
+	}
+	e.Payload = append([]byte(nil), payload...)
+	e.Value = string(payload)
+	e.ReceivedAt = t
+	e.Retained = retained
+	e.QoS = qos
+	e.Updates++
+	e.Restored = false
+	return *e
+}
+
+// Delete removes topic from the cache
+func (c *Cache) Delete(topic string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, topic)
+}
+
+// Len returns the number of cached topics
+func (c *Cache) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.entries)
+}
+
+// Range calls f for a snapshot of all entries sorted by topic until f returns false
+func (c *Cache) Range(f func(Entry) bool) {
+	c.lock.RLock()
+	entries := make([]Entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, *e)
+	}
+	c.lock.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Topic < entries[j].Topic
+	})
+	for _, e := range entries {
+		if !f(e) {
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func setNow(t *testing.T, ts time.Time) {
+	now = func() time.Time { return ts }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func TestSetGet(t *testing.T) {
+	c := New()
+	if _, found := c.Get("sensor/space/status"); found {
+		t.Errorf("unexpected entry in empty cache")
+	}
+
+	first := time.Date(2022, 3, 1, 18, 0, 0, 0, time.UTC)
+	setNow(t, first)
+	c.Set("sensor/space/status", []byte("closed"), true, 0)
+
+	second := first.Add(time.Minute)
+	setNow(t, second)
+	payload := []byte("open")
+	c.Set("sensor/space/status", payload, false, 1)
+	payload[0] = 'x'
+
+	third := second.Add(time.Minute)
+	setNow(t, third)
+	c.Set("sensor/space/status", []byte("open"), false, 1)
+
+	have, found := c.Get("sensor/space/status")
+	if !found {
+		t.Fatalf("entry not found")
+	}
+	want := Entry{
+		Topic:      "sensor/space/status",
+		Payload:    []byte("open"),
+		Value:      "open",
+		ReceivedAt: third,
+		ChangedAt:  second,
+		FirstSeen:  first,
+		Retained:   false,
+		QoS:        1,
+		Updates:    3,
+	}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("Invalid entry. \n%s", diff)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	c := New()
+	c.Set("sensor/space/status", []byte("open"), false, 0)
+	c.Delete("sensor/space/status")
+	if _, found := c.Get("sensor/space/status"); found {
+		t.Errorf("entry not deleted")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", c.Len())
+	}
+}
+
+func TestRange(t *testing.T) {
+	c := New()
+	for _, topic := range []string{"c", "a", "b"} {
+		c.Set(topic, []byte(topic), false, 0)
+	}
+	have := []string{}
+	c.Range(func(e Entry) bool {
+		have = append(have, e.Topic)
+		return e.Topic != "b"
+	})
+	if diff := cmp.Diff([]string{"a", "b"}, have); diff != "" {
+		t.Errorf("Invalid range. \n%s", diff)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	c := New()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			topic := fmt.Sprintf("sensor/%d", i%2)
+			for j := 0; j < 100; j++ {
+				c.Set(topic, []byte(fmt.Sprint(j)), false, 0)
+				c.Get(topic)
+				c.Range(func(Entry) bool { return true })
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, topic := range []string{"sensor/0", "sensor/1"} {
+		e, _ := c.Get(topic)
+		if e.Updates != 400 {
+			t.Errorf("%s: Updates = %d, want 400", topic, e.Updates)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	ts := time.Date(2022, 3, 1, 18, 0, 0, 0, time.UTC)
+	setNow(t, ts)
+	c := New()
+	c.Set("sensor/space/status", []byte("open"), true, 0)
+	c.Set("sensor/space/member/present", []byte("4"), false, 0)
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := c.Save(path); err != nil {
+		t.Fatalf("unable to save: %v", err)
+	}
+
+	restored := New()
+	restored.Set("sensor/space/member/present", []byte("5"), false, 0)
+	n, err := restored.Load(path)
+	if err != nil {
+		t.Fatalf("unable to load: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("restored %d entries, want 1", n)
+	}
+	have, _ := restored.Get("sensor/space/status")
+	want, _ := c.Get("sensor/space/status")
+	want.Restored = true
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("Invalid restored entry. \n%s", diff)
+	}
+	if e, _ := restored.Get("sensor/space/member/present"); e.Value != "5" || e.Restored {
+		t.Errorf("live entry overwritten by snapshot: %+v", e)
+	}
+
+	restored.Set("sensor/space/status", []byte("closed"), false, 0)
+	if e, _ := restored.Get("sensor/space/status"); e.Restored {
+		t.Errorf("updated entry still marked as restored")
+	}
+}
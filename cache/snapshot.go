@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Save atomically writes all entries to path
+func (c *Cache) Save(path string) (err error) {
+	entries := []Entry{}
+	c.Range(func(e Entry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	err = json.NewEncoder(f).Encode(entries)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load restores the entries saved to path, newer entries already in the
+// cache are kept. Restored entries are marked as such.
+func (c *Cache) Load(path string) (restored int, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	entries := []Entry{}
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return 0, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i := range entries {
+		e := entries[i]
+		current, found := c.entries[e.Topic]
+		if found && !current.ReceivedAt.Before(e.ReceivedAt) {
+			continue
+		}
+		e.Value = string(e.Payload)
+		e.Restored = true
+		c.entries[e.Topic] = &e
+		restored++
+	}
+	return restored, nil
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"sync"
+)
+
+// Event is a message published on a Hub, IDs increase monotonically
+type Event struct {
+	ID    uint64
+	Topic string
+	Data  []byte
+}
+
+// Subscription receives the events published after subscribing. C is
+// closed when the subscriber was too slow or unsubscribed.
+type Subscription struct {
+	C      chan Event
+	closed bool
+}
+
+// Hub fans out events to subscribers without blocking the publisher and
+// keeps the last events for resuming subscribers
+type Hub struct {
+	lock        sync.Mutex
+	seq         uint64
+	replay      []Event
+	size        int
+	buffer      int
+	subscribers map[*Subscription]struct{}
+	// OnDrop is called when a slow subscriber is dropped
+	OnDrop func()
+}
+
+// NewHub creates a hub keeping replay events for resuming, each
+// subscriber may lag behind by buffer events before it is dropped
+func NewHub(replay, buffer int) *Hub {
+	return &Hub{
+		size:        replay,
+		buffer:      buffer,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish sends an event to all subscribers, subscribers with a full
+// buffer are dropped
+func (h *Hub) Publish(topic string, data []byte) Event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.seq++
+	e := Event{ID: h.seq, Topic: topic, Data: data}
+	if h.size > 0 {
+		if len(h.replay) == h.size {
+			copy(h.replay, h.replay[1:])
+			h.replay = h.replay[:h.size-1]
+		}
+		h.replay = append(h.replay, e)
+	}
+	for sub := range h.subscribers {
+		select {
+		case sub.C <- e:
+		default:
+			h.unsubscribe(sub)
+			if h.OnDrop != nil {
+				h.OnDrop()
+			}
+		}
+	}
+	return e
+}
+
+// Subscribe registers a subscriber. With a lastID the events published
+// after it are returned, resumed is false if they are no longer available.
+func (h *Hub) Subscribe(lastID uint64) (sub *Subscription, missed []Event, resumed bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sub = &Subscription{C: make(chan Event, h.buffer)}
+	h.subscribers[sub] = struct{}{}
+	if lastID == 0 || lastID > h.seq {
+		return sub, nil, false
+	}
+	if lastID == h.seq {
+		return sub, nil, true
+	}
+	if len(h.replay) == 0 || h.replay[0].ID > lastID+1 {
+		return sub, nil, false
+	}
+	for _, e := range h.replay {
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	return sub, missed, true
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.unsubscribe(sub)
+}
+
+func (h *Hub) unsubscribe(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(h.subscribers, sub)
+	close(sub.C)
+}
+
+// LastID returns the ID of the last published event
+func (h *Hub) LastID() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.seq
+}
+
+// Subscribers returns the number of subscribers
+func (h *Hub) Subscribers() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.subscribers)
+}
@@ -0,0 +1,41 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	h := NewHub(1, 8)
+	for _, topic := range []string{"a", "b", "c"} {
+		h.Publish(topic, nil)
+	}
+	if _, _, resumed := h.Subscribe(1); resumed {
+		t.Errorf("resumed beyond replay buffer")
+	}
+	_, missed, resumed := h.Subscribe(2)
+	if !resumed || len(missed) != 1 || missed[0].Topic != "c" {
+		t.Errorf("invalid resume: %v %+v", resumed, missed)
+	}
+	if _, missed, resumed := h.Subscribe(3); !resumed || len(missed) != 0 {
+		t.Errorf("invalid resume at last event: %v %+v", resumed, missed)
+	}
+}
+
+func TestDropSlowSubscriber(t *testing.T) {
+	h := NewHub(0, 1)
+	dropped := 0
+	h.OnDrop = func() { dropped++ }
+	sub, _, _ := h.Subscribe(0)
+	h.Publish("a", nil)
+	h.Publish("b", nil)
+	if dropped != 1 || h.Subscribers() != 0 {
+		t.Errorf("slow subscriber not dropped")
+	}
+	if e := <-sub.C; e.Topic != "a" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Errorf("channel of dropped subscriber not closed")
+	}
+	h.Unsubscribe(sub)
+}
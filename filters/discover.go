@@ -0,0 +1,117 @@
+package filters
+
+import (
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+// TopicFuncs are the template funcs taking a topic as first argument, by
+// their number of arguments. A piped value is passed as the last argument,
+// so it is the topic only for funcs with a single argument.
+var TopicFuncs = map[string]int{
+	"mqtt":        1,
+	"mqttfresh":   1,
+	"mqttupdated": 1,
+	"mqttchanged": 1,
+	"mqttage":     1,
+	"mqttjson":    2,
+	"mqttmatch":   1,
+	"mqttmin":     2,
+	"mqttmax":     2,
+	"mqttavg":     2,
+	"mqttrate":    2,
+	"mqttlast":    2,
+}
+
+// TemplateTopics walks the parse trees of t and collects the topic literals
+// passed to funcs. dynamic counts the calls whose topic can not be resolved
+// statically.
+func TemplateTopics(t *template.Template, funcs map[string]int) (topics []string, dynamic int) {
+	d := &discovery{
+		funcs:  funcs,
+		topics: map[string]bool{},
+	}
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			d.walk(tmpl.Tree.Root)
+		}
+	}
+	for topic := range d.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, d.dynamic
+}
+
+type discovery struct {
+	funcs   map[string]int
+	topics  map[string]bool
+	dynamic int
+}
+
+func (d *discovery) walk(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			d.walk(child)
+		}
+	case *parse.ActionNode:
+		d.walk(n.Pipe)
+	case *parse.IfNode:
+		d.walkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		d.walkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		d.walkBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		d.walk(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for i, cmd := range n.Cmds {
+			d.walkCommand(n.Cmds, i)
+			for _, arg := range cmd.Args {
+				d.walk(arg)
+			}
+		}
+	}
+}
+
+func (d *discovery) walkBranch(n *parse.BranchNode) {
+	d.walk(n.Pipe)
+	d.walk(n.List)
+	d.walk(n.ElseList)
+}
+
+// walkCommand records the topic of cmds[i] if it calls one of the topic funcs
+func (d *discovery) walkCommand(cmds []*parse.CommandNode, i int) {
+	ident, ok := cmds[i].Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return
+	}
+	arity, ok := d.funcs[ident.Ident]
+	if !ok {
+		return
+	}
+	var arg parse.Node
+	explicit := len(cmds[i].Args) - 1
+	switch {
+	case explicit >= arity:
+		arg = cmds[i].Args[1]
+	case i > 0 && arity == 1 && len(cmds[i-1].Args) == 1:
+		// topic is piped in from the previous command
+		arg = cmds[i-1].Args[0]
+	}
+	// other piped values fill the last argument instead of the topic, e.g.
+	// {{"x" | mqttavg "10m"}} calls mqttavg "10m" "x", these are dynamic
+	if s, ok := arg.(*parse.StringNode); ok {
+		d.topics[s.Text] = true
+		return
+	}
+	d.dynamic++
+}
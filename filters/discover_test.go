@@ -0,0 +1,75 @@
+package filters
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTemplateTopics(t *testing.T) {
+	funcs := template.FuncMap{
+		"mqtt":      func(topic string) string { return "" },
+		"mqttfresh": func(topic string) bool { return false },
+		"mqttjson":  func(topic, path string) string { return "" },
+		"mqttavg":   func(topic, window string) interface{} { return nil },
+		"jsonize":   Jsonize,
+	}
+	for _, tc := range []struct {
+		name    string
+		text    string
+		topics  []string
+		dynamic int
+	}{
+		{
+			name:   "direct literal",
+			text:   `{{mqtt "sensor/space/status"}}`,
+			topics: []string{"sensor/space/status"},
+		},
+		{
+			name:   "piped literal",
+			text:   `{{"sensor/space/member/present" | mqtt | jsonize "int"}}`,
+			topics: []string{"sensor/space/member/present"},
+		},
+		{
+			name:   "nested pipe in if",
+			text:   `{{if eq ("sensor/space/status" | mqtt) "open"}}true{{else}}{{mqtt "sensor/door"}}{{end}}`,
+			topics: []string{"sensor/door", "sensor/space/status"},
+		},
+		{
+			name:   "range and with blocks",
+			text:   `{{range $i := mqtt "a"}}{{mqttjson "b" "x"}}{{end}}{{with mqttfresh "c"}}{{mqtt "d"}}{{end}}`,
+			topics: []string{"a", "b", "c", "d"},
+		},
+		{
+			name:    "dynamic variable",
+			text:    `{{$t := "sensor/space/status"}}{{mqtt $t}}{{$t | mqttfresh}}{{mqtt "e"}}`,
+			topics:  []string{"e"},
+			dynamic: 2,
+		},
+		{
+			name:   "explicit arguments",
+			text:   `{{mqttavg "sensor/power/main/total" "10m"}}{{mqttjson "g" "x" | jsonize "int"}}`,
+			topics: []string{"g", "sensor/power/main/total"},
+		},
+		{
+			name:    "piped last argument",
+			text:    `{{"sensor/power/main/total" | mqttavg "10m"}}{{"x" | mqttjson "h"}}`,
+			dynamic: 2,
+		},
+		{
+			name:   "duplicates",
+			text:   `{{mqtt "f"}}{{"f" | mqtt}}`,
+			topics: []string{"f"},
+		},
+	} {
+		tmpl := template.Must(template.New("status.json").Funcs(funcs).Parse(tc.text))
+		topics, dynamic := TemplateTopics(tmpl, TopicFuncs)
+		if diff := cmp.Diff(tc.topics, topics); diff != "" {
+			t.Errorf("%s: invalid topics. \n%s", tc.name, diff)
+		}
+		if dynamic != tc.dynamic {
+			t.Errorf("%s: dynamic = %d, want %d", tc.name, dynamic, tc.dynamic)
+		}
+	}
+}
@@ -3,32 +3,86 @@ package filters
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/b4ckspace/spacestatus/cache"
 	"github.com/b4ckspace/spacestatus/metrics"
 )
 
-func MqttLoadForCache(cache *sync.Map) func(string) string {
+// Missing is returned by mqtt for topics without a current value
+const Missing = "<nil>"
+
+// Lookup returns the current cache entry for a topic
+type Lookup func(topic string) (cache.Entry, bool)
+
+func MqttLoad(lookup Lookup) func(string) string {
 	return func(t string) string {
-		value, found := cache.Load(t)
-		if found {
-			metrics.Count("spacestatus_mqtt_query{state=\"success\"}")
-		} else {
+		entry, found := lookup(t)
+		if !found {
 			metrics.Count("spacestatus_mqtt_query{state=\"failed\"}")
 			metrics.Count(fmt.Sprintf("spacestatus_mqtt_query_fails{state=\"%s\"}", t))
+			return Missing
 		}
-		valueStr, ok := value.(string)
-		if !ok {
-			valueStr = fmt.Sprintf("%v", value)
+		metrics.Count("spacestatus_mqtt_query{state=\"success\"}")
+		return entry.Value
+	}
+}
+
+// MqttFresh reports whether a topic has a current value
+func MqttFresh(lookup Lookup) func(string) bool {
+	return func(t string) bool {
+		_, found := lookup(t)
+		return found
+	}
+}
+
+// MqttUpdated returns the unix time of the last message on a topic
+func MqttUpdated(lookup Lookup) func(string) interface{} {
+	return func(t string) interface{} {
+		entry, found := lookup(t)
+		if !found {
+			return Missing
 		}
-		return valueStr
+		return entry.ReceivedAt.Unix()
 	}
 }
 
+// MqttChanged returns the unix time a topic last changed its value
+func MqttChanged(lookup Lookup) func(string) interface{} {
+	return func(t string) interface{} {
+		entry, found := lookup(t)
+		if !found {
+			return Missing
+		}
+		return entry.ChangedAt.Unix()
+	}
+}
+
+// MqttAge returns the seconds since the last message on a topic
+func MqttAge(lookup Lookup) func(string) interface{} {
+	return func(t string) interface{} {
+		entry, found := lookup(t)
+		if !found {
+			return Missing
+		}
+		return int64(time.Since(entry.ReceivedAt) / time.Second)
+	}
+}
+
+// Rfc3339 formats a unix time as RFC3339
+func Rfc3339(unix interface{}) string {
+	ts, ok := unix.(int64)
+	if !ok {
+		return Missing
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
 func CsvList(csv string) []string {
 	if csv == "" {
 		return []string{}
@@ -37,6 +91,9 @@ func CsvList(csv string) []string {
 }
 
 func Jsonize(mustType string, data interface{}) string {
+	if data == Missing {
+		return "null"
+	}
 	var err error
 	var dataString string
 	oldData := data
@@ -57,7 +114,16 @@ func Jsonize(mustType string, data interface{}) string {
 			}
 		}
 	case "int":
-		_, ok = data.(int)
+		switch d := data.(type) {
+		case int, int64:
+			ok = true
+		case float64:
+			// json numbers
+			if d == math.Trunc(d) {
+				data = int64(d)
+				ok = true
+			}
+		}
 		if !ok {
 			dataString, ok = data.(string)
 			data, err = strconv.ParseInt(dataString, 10, 64)
@@ -76,6 +142,16 @@ func Jsonize(mustType string, data interface{}) string {
 		}
 	case "[]string":
 		_, ok = data.([]string)
+		if list, isList := data.([]interface{}); isList {
+			// json arrays
+			strs := make([]string, 0, len(list))
+			for _, item := range list {
+				if str, isStr := item.(string); isStr {
+					strs = append(strs, str)
+				}
+			}
+			data, ok = strs, len(strs) == len(list)
+		}
 		if !ok {
 			data = []string{}
 		}
@@ -90,7 +166,10 @@ func Jsonize(mustType string, data interface{}) string {
 			data = []int{}
 		}
 	case "[]float":
-		_, ok = data.([]float32)
+		switch data.(type) {
+		case []float32, []float64:
+			ok = true
+		}
 		if !ok {
 			data = []float32{}
 		}
@@ -0,0 +1,152 @@
+package filters
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/b4ckspace/spacestatus/cache"
+)
+
+func TestJsonize(t *testing.T) {
+	for _, tc := range []struct {
+		mustType string
+		data     interface{}
+		want     string
+	}{
+		{"int", "42", "42"},
+		{"int", int64(42), "42"},
+		{"int", float64(42), "42"},
+		{"int", Missing, "null"},
+		{"float", "21.3", "21.3"},
+		{"float", float64(21.3), "21.3"},
+		{"bool", "true", "true"},
+		{"string", "open", `"open"`},
+		{"[]string", []string{"a", "b"}, `["a","b"]`},
+		{"[]string", []interface{}{"a", "b"}, `["a","b"]`},
+	} {
+		if have := Jsonize(tc.mustType, tc.data); have != tc.want {
+			t.Errorf("Jsonize(%q, %#v) = %s, want %s", tc.mustType, tc.data, have, tc.want)
+		}
+	}
+}
+
+func TestMqttJSON(t *testing.T) {
+	c := cache.New()
+	c.Set("zigbee2mqtt/shelf", []byte(`{"temperature":21.3,"humidity":40,"sensors":[{"id":"a"},{"id":"b"}],"ok":true}`), false, 0)
+	c.Set("sensor/space/status", []byte(`open`), false, 0)
+	mqttjson := MqttJSON(c.Get)
+
+	for _, tc := range []struct {
+		topic string
+		path  string
+		want  interface{}
+	}{
+		{"zigbee2mqtt/shelf", "temperature", 21.3},
+		{"zigbee2mqtt/shelf", "sensors[1].id", "b"},
+		{"zigbee2mqtt/shelf", "sensors.0.id", "a"},
+		{"zigbee2mqtt/shelf", "ok", true},
+		{"zigbee2mqtt/shelf", "sensors[2].id", Missing},
+		{"zigbee2mqtt/shelf", "pressure", Missing},
+		{"sensor/space/status", "value", Missing},
+		{"sensor/door", "value", Missing},
+	} {
+		if diff := cmp.Diff(tc.want, mqttjson(tc.topic, tc.path)); diff != "" {
+			t.Errorf("mqttjson %q %q: \n%s", tc.topic, tc.path, diff)
+		}
+	}
+
+	have := mqttjson("zigbee2mqtt/shelf", "")
+	want := map[string]interface{}{}
+	_ = json.Unmarshal([]byte(`{"temperature":21.3,"humidity":40,"sensors":[{"id":"a"},{"id":"b"}],"ok":true}`), &want)
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("Invalid document. \n%s", diff)
+	}
+}
+
+func TestMqttMatch(t *testing.T) {
+	c := cache.New()
+	c.Set("sensor/temperature/lounge/window", []byte("19.5"), false, 0)
+	c.Set("sensor/temperature/hackcenter/shelf", []byte("21.3"), false, 0)
+	c.Set("sensor/temperature/outside", []byte("3.1"), false, 0)
+	mqttmatch := MqttMatch(c.Range)
+
+	want := []Match{
+		{Topic: "sensor/temperature/hackcenter/shelf", Value: "21.3", Segments: []string{"hackcenter", "shelf"}},
+		{Topic: "sensor/temperature/lounge/window", Value: "19.5", Segments: []string{"lounge", "window"}},
+	}
+	if diff := cmp.Diff(want, mqttmatch("sensor/temperature/+/+")); diff != "" {
+		t.Errorf("Invalid matches. \n%s", diff)
+	}
+}
+
+func TestMqttTimes(t *testing.T) {
+	changed := time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC)
+	received := changed.Add(10 * time.Minute)
+	entries := map[string]cache.Entry{
+		// re-published with the same value
+		"sensor/space/status": {Topic: "sensor/space/status", Value: "open", ReceivedAt: received, ChangedAt: changed},
+		// restored from a snapshot with its original timestamps
+		"sensor/door": {Topic: "sensor/door", Value: "closed", ReceivedAt: changed, ChangedAt: changed, Restored: true},
+	}
+	lookup := func(t string) (cache.Entry, bool) {
+		e, found := entries[t]
+		return e, found
+	}
+	mqttupdated, mqttchanged, mqttage := MqttUpdated(lookup), MqttChanged(lookup), MqttAge(lookup)
+
+	for _, tc := range []struct {
+		topic            string
+		updated, changed interface{}
+	}{
+		{"sensor/space/status", received.Unix(), changed.Unix()},
+		{"sensor/door", changed.Unix(), changed.Unix()},
+		{"sensor/missing", Missing, Missing},
+	} {
+		if have := mqttupdated(tc.topic); have != tc.updated {
+			t.Errorf("mqttupdated %q = %v, want %v", tc.topic, have, tc.updated)
+		}
+		if have := mqttchanged(tc.topic); have != tc.changed {
+			t.Errorf("mqttchanged %q = %v, want %v", tc.topic, have, tc.changed)
+		}
+	}
+	age, ok := mqttage("sensor/door").(int64)
+	if want := int64(time.Since(changed) / time.Second); !ok || age < want-1 || age > want+1 {
+		t.Errorf("mqttage = %v, want %d", age, want)
+	}
+	if have := mqttage("sensor/missing"); have != Missing {
+		t.Errorf("mqttage of missing topic = %v", have)
+	}
+
+	for _, tc := range []struct {
+		unix interface{}
+		want string
+	}{
+		{changed.Unix(), "2024-03-31T01:30:00Z"},
+		{Missing, Missing},
+		{"1711848600", Missing},
+	} {
+		if have := Rfc3339(tc.unix); have != tc.want {
+			t.Errorf("Rfc3339(%#v) = %s, want %s", tc.unix, have, tc.want)
+		}
+	}
+	if have := Jsonize("string", Rfc3339(Missing)); have != "null" {
+		t.Errorf("missing time encoded as %s", have)
+	}
+}
+
+func TestMqttChangedRepublished(t *testing.T) {
+	c := cache.New()
+	first := c.Set("sensor/space/status", []byte("open"), false, 0)
+	time.Sleep(time.Millisecond)
+	c.Set("sensor/space/status", []byte("open"), false, 0)
+	e, _ := c.Get("sensor/space/status")
+	if !e.ChangedAt.Equal(first.ChangedAt) || !e.ReceivedAt.After(first.ReceivedAt) {
+		t.Errorf("republished value: changed %s, received %s, first %s", e.ChangedAt, e.ReceivedAt, first.ChangedAt)
+	}
+	if have := MqttChanged(c.Get)("sensor/space/status"); have != first.ChangedAt.Unix() {
+		t.Errorf("mqttchanged = %v, want %d", have, first.ChangedAt.Unix())
+	}
+}
@@ -0,0 +1,33 @@
+package filters
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/history"
+)
+
+// MqttAggregate applies aggregate to the samples a topic received within
+// a duration, e.g. mqttavg "sensor/temperature/hackcenter/shelf" "10m"
+func MqttAggregate(store *history.Store, aggregate func([]history.Sample) (float64, bool)) func(string, string) interface{} {
+	return func(t, window string) interface{} {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			log.WithError(err).Infof("invalid history window for %s", t)
+			return Missing
+		}
+		value, ok := aggregate(store.Window(t, d))
+		if !ok {
+			return Missing
+		}
+		return value
+	}
+}
+
+// MqttLast returns the last n values a topic received
+func MqttLast(store *history.Store) func(string, int) []float64 {
+	return func(t string, n int) []float64 {
+		return history.Last(store.Window(t, store.MaxAge()), n)
+	}
+}
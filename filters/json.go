@@ -0,0 +1,60 @@
+package filters
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MqttJSON extracts the value at path from a topic's JSON payload. Path
+// elements are separated by dots, array elements are selected by index,
+// e.g. "sensors.0.temperature" or "sensors[0].temperature".
+func MqttJSON(lookup Lookup) func(string, string) interface{} {
+	return func(t, path string) interface{} {
+		entry, found := lookup(t)
+		if !found {
+			return Missing
+		}
+		var data interface{}
+		err := json.Unmarshal(entry.Payload, &data)
+		if err != nil {
+			log.WithError(err).Debugf("invalid json payload on %s", t)
+			return Missing
+		}
+		value, found := JSONPath(data, path)
+		if !found {
+			log.Debugf("path %q not found on %s", path, t)
+			return Missing
+		}
+		return value
+	}
+}
+
+// JSONPath returns the value at path in decoded json data
+func JSONPath(data interface{}, path string) (interface{}, bool) {
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			continue
+		}
+		switch d := data.(type) {
+		case map[string]interface{}:
+			value, found := d[key]
+			if !found {
+				return nil, false
+			}
+			data = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, false
+			}
+			data = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
@@ -0,0 +1,36 @@
+package filters
+
+import (
+	"github.com/b4ckspace/spacestatus/cache"
+	"github.com/b4ckspace/spacestatus/topic"
+)
+
+// Each calls f for all current cache entries sorted by topic until f returns false
+type Each func(f func(cache.Entry) bool)
+
+// Match is a cached topic matching a wildcard pattern
+type Match struct {
+	Topic string
+	Value string
+	// Segments are the topic levels matched by the wildcards
+	Segments []string
+}
+
+// MqttMatch returns the cached topics matching an MQTT wildcard pattern
+func MqttMatch(each Each) func(string) []Match {
+	return func(pattern string) []Match {
+		matches := []Match{}
+		each(func(e cache.Entry) bool {
+			segments, ok := topic.Capture(pattern, e.Topic)
+			if ok {
+				matches = append(matches, Match{
+					Topic:    e.Topic,
+					Value:    e.Value,
+					Segments: segments,
+				})
+			}
+			return true
+		})
+		return matches
+	}
+}
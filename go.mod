@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/google/go-cmp v0.5.7
+	github.com/gorilla/websocket v1.4.2
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/sirupsen/logrus v1.8.1
 )
@@ -0,0 +1,98 @@
+package history
+
+import "time"
+
+// Min returns the smallest value of samples
+func Min(samples []Sample) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	min := samples[0].Value
+	for _, sample := range samples[1:] {
+		if sample.Value < min {
+			min = sample.Value
+		}
+	}
+	return min, true
+}
+
+// Max returns the largest value of samples
+func Max(samples []Sample) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	max := samples[0].Value
+	for _, sample := range samples[1:] {
+		if sample.Value > max {
+			max = sample.Value
+		}
+	}
+	return max, true
+}
+
+// Avg returns the arithmetic mean of samples
+func Avg(samples []Sample) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	sum := 0.0
+	for _, sample := range samples {
+		sum += sample.Value
+	}
+	return sum / float64(len(samples)), true
+}
+
+// Rate returns the change per second between the first and last sample
+func Rate(samples []Sample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	seconds := last.Time.Sub(first.Time).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+	return (last.Value - first.Value) / seconds, true
+}
+
+// Last returns the values of the last n samples
+func Last(samples []Sample, n int) []float64 {
+	if n < 0 {
+		n = 0
+	}
+	if n < len(samples) {
+		samples = samples[len(samples)-n:]
+	}
+	values := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		values = append(values, sample.Value)
+	}
+	return values
+}
+
+// Aggregates maps names to aggregate functions usable for Downsample
+var Aggregates = map[string]func([]Sample) (float64, bool){
+	"avg": Avg,
+	"min": Min,
+	"max": Max,
+}
+
+// Downsample aggregates samples into buckets of step starting at from,
+// each result is timestamped with the start of its bucket. Samples must
+// be sorted by time, empty buckets are skipped.
+func Downsample(samples []Sample, from time.Time, step time.Duration, aggregate func([]Sample) (float64, bool)) []Sample {
+	result := []Sample{}
+	for len(samples) > 0 {
+		bucket := from.Add(samples[0].Time.Sub(from) / step * step)
+		end := bucket.Add(step)
+		n := 0
+		for n < len(samples) && samples[n].Time.Before(end) {
+			n++
+		}
+		if value, ok := aggregate(samples[:n]); ok {
+			result = append(result, Sample{Time: bucket, Value: value})
+		}
+		samples = samples[n:]
+	}
+	return result
+}
@@ -0,0 +1,185 @@
+package history
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample is a numeric value received at a point in time
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Store keeps a bounded ring buffer of samples per topic, safe for
+// concurrent use. Memory is bounded by size * maxTopics samples.
+type Store struct {
+	lock      sync.RWMutex
+	size      int
+	maxAge    time.Duration
+	interval  time.Duration
+	maxTopics int
+	series    map[string]*ring
+}
+
+// New creates a store keeping at most size samples no older than maxAge for
+// at most maxTopics topics. Samples closer than interval to the previous
+// sample of a topic are dropped.
+func New(size int, maxAge, interval time.Duration, maxTopics int) *Store {
+	return &Store{
+		size:      size,
+		maxAge:    maxAge,
+		interval:  interval,
+		maxTopics: maxTopics,
+		series:    map[string]*ring{},
+	}
+}
+
+var (
+	// ErrMaxTopics is returned by Add for a new topic if maxTopics topics
+	// with recent samples are recorded already
+	ErrMaxTopics = errors.New("too many topics")
+	// ErrInterval is returned by Add for samples closer than interval to
+	// the previous sample of the topic
+	ErrInterval = errors.New("sample within interval")
+)
+
+// Add records a sample for topic, it returns ErrMaxTopics or ErrInterval
+// if the sample was dropped
+func (s *Store) Add(topic string, t time.Time, value float64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, found := s.series[topic]
+	if !found {
+		if len(s.series) >= s.maxTopics {
+			s.prune(t)
+		}
+		if len(s.series) >= s.maxTopics {
+			return ErrMaxTopics
+		}
+		r = newRing(s.size)
+		s.series[topic] = r
+	}
+	ts := t.UnixNano()
+	if last, ok := r.last(); ok && ts-last < int64(s.interval) {
+		return ErrInterval
+	}
+	r.add(ts, value)
+	return nil
+}
+
+// prune removes topics without samples newer than maxAge
+func (s *Store) prune(now time.Time) {
+	oldest := now.Add(-s.maxAge).UnixNano()
+	for topic, r := range s.series {
+		if last, ok := r.last(); !ok || last < oldest {
+			delete(s.series, topic)
+		}
+	}
+}
+
+// Samples returns the samples of topic in [from, to] sorted by time
+func (s *Store) Samples(topic string, from, to time.Time) []Sample {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	samples := []Sample{}
+	r, found := s.series[topic]
+	if !found {
+		return samples
+	}
+	if oldest := time.Now().Add(-s.maxAge); from.Before(oldest) {
+		from = oldest
+	}
+	r.each(func(ts int64, value float64) {
+		t := time.Unix(0, ts)
+		if t.Before(from) || t.After(to) {
+			return
+		}
+		samples = append(samples, Sample{Time: t, Value: value})
+	})
+	return samples
+}
+
+// Window returns the samples of topic received within d before now
+func (s *Store) Window(topic string, d time.Duration) []Sample {
+	now := time.Now()
+	return s.Samples(topic, now.Add(-d), now)
+}
+
+// Topics returns the topics with recorded samples
+func (s *Store) Topics() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	topics := make([]string, 0, len(s.series))
+	for topic := range s.series {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// MaxAge returns the retention of the store
+func (s *Store) MaxAge() time.Duration {
+	return s.maxAge
+}
+
+// Parse converts a payload to a sample value. Besides numbers, booleans
+// and the states open/on and closed/off are recorded as 1 and 0.
+func Parse(payload string) (float64, bool) {
+	payload = strings.TrimSpace(payload)
+	if v, err := strconv.ParseFloat(payload, 64); err == nil {
+		return v, true
+	}
+	switch strings.ToLower(payload) {
+	case "true", "open", "on":
+		return 1, true
+	case "false", "closed", "off":
+		return 0, true
+	}
+	return 0, false
+}
+
+// ring is a fixed size ring buffer of samples
+type ring struct {
+	times  []int64
+	values []float64
+	start  int
+	n      int
+}
+
+func newRing(size int) *ring {
+	return &ring{
+		times:  make([]int64, size),
+		values: make([]float64, size),
+	}
+}
+
+func (r *ring) add(ts int64, value float64) {
+	i := (r.start + r.n) % len(r.times)
+	r.times[i] = ts
+	r.values[i] = value
+	if r.n < len(r.times) {
+		r.n++
+	} else {
+		r.start = (r.start + 1) % len(r.times)
+	}
+}
+
+func (r *ring) last() (int64, bool) {
+	if r.n == 0 {
+		return 0, false
+	}
+	return r.times[(r.start+r.n-1)%len(r.times)], true
+}
+
+// each calls f for all samples from oldest to newest
+func (r *ring) each(f func(ts int64, value float64)) {
+	for i := 0; i < r.n; i++ {
+		j := (r.start + i) % len(r.times)
+		f(r.times[j], r.values[j])
+	}
+}
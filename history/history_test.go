@@ -0,0 +1,92 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRing(t *testing.T) {
+	s := New(3, time.Hour, 0, 10)
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		s.Add("sensor/power/main/total", start.Add(time.Duration(i)*time.Second), float64(i))
+	}
+	have := s.Samples("sensor/power/main/total", start, start.Add(time.Minute))
+	want := []Sample{
+		{Time: start.Add(2 * time.Second), Value: 2},
+		{Time: start.Add(3 * time.Second), Value: 3},
+		{Time: start.Add(4 * time.Second), Value: 4},
+	}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("Invalid samples. \n%s", diff)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	s := New(10, time.Hour, 10*time.Second, 1)
+	now := time.Now()
+	if err := s.Add("a", now, 1); err != nil {
+		t.Errorf("first sample dropped: %v", err)
+	}
+	if err := s.Add("a", now.Add(time.Second), 2); err != ErrInterval {
+		t.Errorf("sample within interval: %v, want %v", err, ErrInterval)
+	}
+	if err := s.Add("b", now, 1); err != ErrMaxTopics {
+		t.Errorf("sample beyond max topics: %v, want %v", err, ErrMaxTopics)
+	}
+	if len(s.Samples("a", now.Add(-time.Minute), now.Add(time.Minute))) != 1 {
+		t.Errorf("expected a single sample")
+	}
+
+	// topics without recent samples make room for new ones
+	s = New(10, time.Hour, 0, 1)
+	s.Add("a", now.Add(-2*time.Hour), 1)
+	if err := s.Add("b", now, 1); err != nil {
+		t.Errorf("expired topic not pruned: %v", err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Now()
+	samples := []Sample{
+		{Time: start, Value: 10},
+		{Time: start.Add(5 * time.Second), Value: 30},
+		{Time: start.Add(10 * time.Second), Value: 20},
+	}
+	for name, tc := range map[string]struct {
+		f    func([]Sample) (float64, bool)
+		want float64
+	}{
+		"min":  {Min, 10},
+		"max":  {Max, 30},
+		"avg":  {Avg, 20},
+		"rate": {Rate, 1},
+	} {
+		have, ok := tc.f(samples)
+		if !ok || have != tc.want {
+			t.Errorf("%s = %v, %v, want %v", name, have, ok, tc.want)
+		}
+	}
+	if diff := cmp.Diff([]float64{30, 20}, Last(samples, 2)); diff != "" {
+		t.Errorf("Invalid last values. \n%s", diff)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	from := time.Date(2022, 3, 1, 18, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{Time: from.Add(10 * time.Second), Value: 1},
+		{Time: from.Add(50 * time.Second), Value: 3},
+		{Time: from.Add(130 * time.Second), Value: 5},
+	}
+	have := Downsample(samples, from, time.Minute, Avg)
+	want := []Sample{
+		{Time: from, Value: 2},
+		{Time: from.Add(2 * time.Minute), Value: 5},
+	}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("Invalid downsampled samples. \n%s", diff)
+	}
+}
@@ -0,0 +1,87 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operation is a RFC 6902 JSON Patch operation
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON omits the value of remove operations only, as false, 0 and
+// null are valid values of add and replace operations
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	type operation Operation
+	return json.Marshal(operation(o))
+}
+
+// Diff returns the operations transforming the decoded json document from
+// into to. Objects are compared by key, arrays of equal length by index,
+// other changes replace the value.
+func Diff(from, to interface{}) []Operation {
+	return diff("", from, to, []Operation{})
+}
+
+func diff(path string, from, to interface{}, ops []Operation) []Operation {
+	switch a := from.(type) {
+	case map[string]interface{}:
+		b, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(a) {
+			if _, found := b[key]; !found {
+				ops = append(ops, Operation{Op: "remove", Path: path + "/" + escape(key)})
+			}
+		}
+		for _, key := range sortedKeys(b) {
+			value, found := a[key]
+			if !found {
+				ops = append(ops, Operation{Op: "add", Path: path + "/" + escape(key), Value: b[key]})
+				continue
+			}
+			ops = diff(path+"/"+escape(key), value, b[key], ops)
+		}
+		return ops
+	case []interface{}:
+		b, ok := to.([]interface{})
+		if !ok || len(a) != len(b) {
+			break
+		}
+		for i := range a {
+			ops = diff(path+"/"+strconv.Itoa(i), a[i], b[i], ops)
+		}
+		return ops
+	}
+	if reflect.DeepEqual(from, to) {
+		return ops
+	}
+	return append(ops, Operation{Op: "replace", Path: path, Value: to})
+}
+
+// escape encodes a key as JSON Pointer reference token
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
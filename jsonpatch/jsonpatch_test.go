@@ -0,0 +1,48 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid json %s: %v", s, err)
+	}
+	return v
+}
+
+func TestDiff(t *testing.T) {
+	from := decode(t, `{"state":{"open":false,"message":"closed"},"sensors":{"temperature":[{"value":21.3}],"people_now_present":[{"value":0}]},"a/b":1}`)
+	to := decode(t, `{"state":{"open":true},"sensors":{"temperature":[{"value":21.5}],"people_now_present":[{"value":1},{"value":2}]},"a/b":1,"lastchange":1646157600}`)
+	want := []Operation{
+		{Op: "add", Path: "/lastchange", Value: float64(1646157600)},
+		{Op: "replace", Path: "/sensors/people_now_present", Value: decode(t, `[{"value":1},{"value":2}]`)},
+		{Op: "replace", Path: "/sensors/temperature/0/value", Value: 21.5},
+		{Op: "remove", Path: "/state/message"},
+		{Op: "replace", Path: "/state/open", Value: true},
+	}
+	if diff := cmp.Diff(want, Diff(from, to)); diff != "" {
+		t.Errorf("Invalid patch. \n%s", diff)
+	}
+	if ops := Diff(from, from); len(ops) != 0 {
+		t.Errorf("expected empty patch, got %+v", ops)
+	}
+	if diff := cmp.Diff([]Operation{{Op: "replace", Path: "", Value: "x"}}, Diff(from, "x")); diff != "" {
+		t.Errorf("Invalid root patch. \n%s", diff)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	data, _ := json.Marshal([]Operation{
+		{Op: "replace", Path: "/state/open", Value: false},
+		{Op: "remove", Path: "/state/message"},
+	})
+	want := `[{"op":"replace","path":"/state/open","value":false},{"op":"remove","path":"/state/message"}]`
+	if string(data) != want {
+		t.Errorf("Invalid json %s, want %s", data, want)
+	}
+}
@@ -16,18 +16,36 @@ func main() {
 		log.WithError(err).Fatalf("unable to process env")
 	}
 
-	// mqtt
-	err = s.ConnectMqtt()
-	if err != nil {
-		log.WithError(err).Fatalf("unable to connect mqtt")
-	}
-
 	// template
 	err = s.LoadTemplates()
 	if err != nil {
 		log.WithError(err).Fatalf("unable to load templates")
 	}
 
+	// cache
+	err = s.LoadCache()
+	if err != nil {
+		log.WithError(err).Fatalf("unable to restore cache")
+	}
+
+	// state
+	err = s.RestoreOverride()
+	if err != nil {
+		log.WithError(err).Fatalf("unable to restore state override")
+	}
+
+	// storage
+	err = s.OpenTSDB()
+	if err != nil {
+		log.WithError(err).Fatalf("unable to open tsdb")
+	}
+
+	// mqtt
+	err = s.ConnectMqtt()
+	if err != nil {
+		log.WithError(err).Fatalf("unable to connect mqtt")
+	}
+
 	// metrics
 	metrics.Register(s.GetMux())
 
@@ -9,6 +9,7 @@ import (
 var (
 	lock    = sync.RWMutex{}
 	counter = map[string]int{}
+	gauges  = map[string]func() int{}
 )
 
 // non-blocking call to count metrics
@@ -33,11 +34,31 @@ func Set(metric string, value int) {
 	}()
 }
 
+// Func registers a gauge read from f on every scrape, replacing a previous
+// one of the same name. f must not block.
+func Func(metric string, f func() int) {
+	lock.Lock()
+	defer lock.Unlock()
+	gauges[metric] = f
+}
+
 func Register(mux *http.ServeMux) {
 	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		lock.Lock()
-		defer lock.Unlock()
+		// copy the metrics, so a slow client does not block updates
+		lock.RLock()
+		values := make(map[string]int, len(counter)+len(gauges))
 		for k, v := range counter {
+			values[k] = v
+		}
+		funcs := make(map[string]func() int, len(gauges))
+		for k, f := range gauges {
+			funcs[k] = f
+		}
+		lock.RUnlock()
+		for k, f := range funcs {
+			values[k] = f()
+		}
+		for k, v := range values {
 			fmt.Fprintf(w, "%s %d\n", k, v)
 		}
 	})
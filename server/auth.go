@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// loadTokens merges tokens by name with the "name:token" lines of file,
+// empty lines and lines starting with '#' are skipped
+func loadTokens(name string, tokens map[string]string, file string) (map[string]string, error) {
+	merged := map[string]string{}
+	for n, token := range tokens {
+		merged[n] = token
+	}
+	if file == "" {
+		return merged, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s_FILE: %w", name, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i <= 0 || i == len(text)-1 {
+			return nil, fmt.Errorf("%s_FILE line %d: expected name:token", name, line)
+		}
+		merged[text[:i]] = text[i+1:]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %s_FILE: %w", name, err)
+	}
+	for n, token := range merged {
+		if token == "" {
+			return nil, fmt.Errorf("%s: empty token for %q", name, n)
+		}
+	}
+	return merged, nil
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[7:])
+}
+
+// authorize returns the name of the token the request was made with
+func authorize(tokens map[string]string, r *http.Request) (string, bool) {
+	token := bearerToken(r)
+	if token == "" {
+		return "", false
+	}
+	name, ok := "", false
+	for n, t := range tokens {
+		// compare all tokens in constant time
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			name, ok = n, true
+		}
+	}
+	return name, ok
+}
+
+// requireToken authorizes the request or responds with 401
+func requireToken(w http.ResponseWriter, r *http.Request, tokens map[string]string) (string, bool) {
+	name, ok := authorize(tokens, r)
+	if !ok {
+		w.Header().Set("www-authenticate", "Bearer")
+		http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+	}
+	return name, ok
+}
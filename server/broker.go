@@ -0,0 +1,69 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/metrics"
+)
+
+// brokers tracks which of the configured mqtt servers is in use
+type brokers struct {
+	lock      sync.Mutex
+	attempted string
+	active    string
+}
+
+// servers returns the mqtt servers in the configured connection order
+func (s *Server) servers() []*url.URL {
+	servers := make([]*url.URL, len(s.MqttURLs))
+	copy(servers, s.MqttURLs)
+	if s.MqttOrder == "random" {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		r.Shuffle(len(servers), func(i, j int) {
+			servers[i], servers[j] = servers[j], servers[i]
+		})
+	}
+	return servers
+}
+
+// onConnectAttempt remembers the broker paho is about to connect to
+func (b *brokers) onConnectAttempt(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.attempted = broker.Redacted()
+	log.WithField("broker", b.attempted).Debug("connecting")
+	return tlsCfg
+}
+
+// onConnect marks the last attempted broker as active
+func (b *brokers) onConnect() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.active != b.attempted {
+		if b.active != "" {
+			metrics.Count("spacestatus_mqtt_failovers")
+			metrics.Set(fmt.Sprintf("spacestatus_mqtt_broker{url=%q}", b.active), 0)
+			log.WithFields(log.Fields{
+				"from": b.active,
+				"to":   b.attempted,
+			}).Warn("mqtt broker failover")
+		}
+		b.active = b.attempted
+	}
+	metrics.Set(fmt.Sprintf("spacestatus_mqtt_broker{url=%q}", b.active), 1)
+	return b.active
+}
+
+// ActiveBroker returns the url of the connected mqtt broker
+func (s *Server) ActiveBroker() string {
+	s.brokers.lock.Lock()
+	defer s.brokers.lock.Unlock()
+	return s.brokers.active
+}
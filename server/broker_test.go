@@ -0,0 +1,112 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServers(t *testing.T) {
+	urls := []string{"tcp://a:1883", "tcp://b:1883", "tcp://c:1883"}
+	s := newTestServer(t, map[string]string{"MQTT_URL": strings.Join(urls, ",")})
+	hosts := func(servers []*url.URL) []string {
+		hosts := []string{}
+		for _, u := range servers {
+			hosts = append(hosts, u.String())
+		}
+		return hosts
+	}
+	if diff := cmp.Diff(urls, hosts(s.servers())); diff != "" {
+		t.Errorf("invalid ordered servers. \n%s", diff)
+	}
+	s.MqttOrder = "random"
+	for i := 0; i < 10; i++ {
+		have := hosts(s.servers())
+		sort.Strings(have)
+		if !cmp.Equal(urls, have) {
+			t.Fatalf("random servers %v are not a permutation of %v", have, urls)
+		}
+	}
+
+	setenv(t, "MQTT_ORDER", "round-robin")
+	if _, err := NewServer(); err == nil {
+		t.Errorf("invalid MQTT_ORDER accepted")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	a, _ := url.Parse("tcp://user:secret@a:1883")
+	b, _ := url.Parse("tcp://b:1883")
+	s := &Server{}
+	for _, step := range []struct {
+		attempt *url.URL
+		connect bool
+		active  string
+	}{
+		{a, false, ""},
+		{b, true, "tcp://b:1883"},
+		{a, false, "tcp://b:1883"},
+		{a, true, "tcp://user:xxxxx@a:1883"},
+	} {
+		s.brokers.onConnectAttempt(step.attempt, nil)
+		if step.connect {
+			s.brokers.onConnect()
+		}
+		if have := s.ActiveBroker(); have != step.active {
+			t.Errorf("attempt %s: active broker %q, want %q", step.attempt.Redacted(), have, step.active)
+		}
+	}
+}
+
+func TestConnectSubscribeTimeout(t *testing.T) {
+	// a broker accepting the connection, but never acknowledging subscriptions
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+				// CONNACK, session not present, accepted
+				if _, err := conn.Write([]byte{0x20, 0x02, 0x00, 0x00}); err != nil {
+					return
+				}
+				_, _ = io.Copy(ioutil.Discard, conn)
+			}()
+		}
+	}()
+	s := newTestServer(t, map[string]string{
+		"MQTT_URL":             "tcp://" + l.Addr().String(),
+		"MQTT_CONNECT_TIMEOUT": "200ms",
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ConnectMqtt()
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("connected without subscription")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnectMqtt did not time out")
+	}
+	s.mqttClient.Disconnect(0)
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/topic"
+)
+
+// commandRule allows publishing to a topic via /api/v1/publish
+type commandRule struct {
+	Topic string `json:"topic"`
+	// payloads must be one of Enum or match Pattern
+	Enum    []string `json:"enum"`
+	Pattern string   `json:"pattern"`
+	QoS     byte     `json:"qos"`
+	Retain  bool     `json:"retain"`
+	// Limit publishes per client within Interval
+	Limit    int    `json:"limit"`
+	Interval string `json:"interval"`
+
+	pattern  *regexp.Regexp
+	interval time.Duration
+	lock     sync.Mutex
+	windows  map[string]*rateWindow
+}
+
+// maxRateWindows limits the clients tracked per rule
+const maxRateWindows = 1024
+
+// rateWindow counts the publishes of a client
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// commandConfig is the content of PUBLISH_ALLOWLIST_FILE
+type commandConfig struct {
+	Topics []*commandRule `json:"topics"`
+}
+
+// publishRequest is the body of POST /api/v1/publish
+type publishRequest struct {
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+}
+
+// loadCommands reads the publish allowlist
+func (s *Server) loadCommands() error {
+	s.commands = map[string]*commandRule{}
+	if s.PublishAllowlistFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.PublishAllowlistFile)
+	if err != nil {
+		return fmt.Errorf("unable to read PUBLISH_ALLOWLIST_FILE: %w", err)
+	}
+	var config commandConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return fmt.Errorf("invalid PUBLISH_ALLOWLIST_FILE: %w", err)
+	}
+	for _, rule := range config.Topics {
+		err = rule.compile()
+		if err != nil {
+			return fmt.Errorf("invalid PUBLISH_ALLOWLIST_FILE: topic %q: %w", rule.Topic, err)
+		}
+		if _, found := s.commands[rule.Topic]; found {
+			return fmt.Errorf("invalid PUBLISH_ALLOWLIST_FILE: duplicate topic %q", rule.Topic)
+		}
+		s.commands[rule.Topic] = rule
+	}
+	return nil
+}
+
+// compile validates the rule and prepares its payload pattern
+func (rule *commandRule) compile() (err error) {
+	if err := topic.Valid(rule.Topic); err != nil || strings.ContainsAny(rule.Topic, "+#") {
+		return fmt.Errorf("invalid topic, wildcards are not allowed")
+	}
+	if len(rule.Enum) == 0 && rule.Pattern == "" {
+		return fmt.Errorf("either enum or pattern is required")
+	}
+	if rule.Pattern != "" {
+		rule.pattern, err = regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil {
+			return err
+		}
+	}
+	if rule.QoS > 2 {
+		return fmt.Errorf("invalid qos %d", rule.QoS)
+	}
+	if rule.Limit == 0 {
+		rule.Limit = 1
+	}
+	rule.interval, err = time.ParseDuration(rule.Interval)
+	if err != nil || rule.interval <= 0 || rule.Limit < 0 {
+		return fmt.Errorf("a positive limit and interval are required")
+	}
+	rule.windows = map[string]*rateWindow{}
+	return nil
+}
+
+// validPayload checks payload against the enum and pattern of the rule
+func (rule *commandRule) validPayload(payload string) bool {
+	for _, allowed := range rule.Enum {
+		if payload == allowed {
+			return true
+		}
+	}
+	return rule.pattern != nil && rule.pattern.MatchString(payload)
+}
+
+// allow counts a publish of client, returning the time until it may
+// publish again if the limit is exceeded
+func (rule *commandRule) allow(client string, now time.Time) (time.Duration, bool) {
+	rule.lock.Lock()
+	defer rule.lock.Unlock()
+	window, found := rule.windows[client]
+	if !found && len(rule.windows) >= maxRateWindows {
+		// new clients wait for the oldest active window if there are still
+		// too many after removing the expired ones
+		oldest := now
+		for c, window := range rule.windows {
+			if now.Sub(window.start) >= rule.interval {
+				delete(rule.windows, c)
+			} else if window.start.Before(oldest) {
+				oldest = window.start
+			}
+		}
+		if len(rule.windows) >= maxRateWindows {
+			return oldest.Add(rule.interval).Sub(now), false
+		}
+	}
+	if !found || now.Sub(window.start) >= rule.interval {
+		window = &rateWindow{start: now}
+		rule.windows[client] = window
+	}
+	if window.count >= rule.Limit {
+		return window.start.Add(rule.interval).Sub(now), false
+	}
+	window.count++
+	return 0, true
+}
+
+// client identifies the sender of a request for rate limiting
+func (s *Server) client(r *http.Request) string {
+	if s.PublishClientHeader == "" {
+		return remoteHost(r)
+	}
+	// each proxy appends the address it received the request from, the
+	// entries before the one added by the outermost trusted proxy may be
+	// set by the client
+	var addresses []string
+	for _, header := range r.Header.Values(s.PublishClientHeader) {
+		addresses = append(addresses, strings.Split(header, ",")...)
+	}
+	if len(addresses) < s.PublishTrustedProxies {
+		return remoteHost(r)
+	}
+	if v := strings.TrimSpace(addresses[len(addresses)-s.PublishTrustedProxies]); v != "" {
+		return v
+	}
+	return remoteHost(r)
+}
+
+// handlePublish publishes allowlisted payloads to the broker
+func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request) {
+	if len(s.commands) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	metrics.Count("spacestatus_api_requests{endpoint=\"publish\"}")
+	if r.Method != http.MethodPost {
+		w.Header().Set("allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req publishRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	client := s.client(r)
+	fields := log.Fields{
+		"topic":   req.Topic,
+		"payload": req.Payload,
+		"client":  client,
+	}
+	rule, found := s.commands[req.Topic]
+	if !found {
+		metrics.Count("spacestatus_publish{result=\"forbidden\"}")
+		log.WithFields(fields).Info("publish rejected, topic not allowed")
+		http.Error(w, fmt.Sprintf("publishing to %q is not allowed", req.Topic), http.StatusForbidden)
+		return
+	}
+	count := func(result string) {
+		metrics.Count(fmt.Sprintf("spacestatus_publish{topic=%q,result=%q}", rule.Topic, result))
+	}
+	if !rule.validPayload(req.Payload) {
+		count("invalid")
+		log.WithFields(fields).Info("publish rejected, invalid payload")
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if wait, ok := rule.allow(client, time.Now()); !ok {
+		count("rate_limited")
+		log.WithFields(fields).Info("publish rejected, rate limited")
+		w.Header().Set("retry-after", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	err = s.publishMessage(rule.Topic, rule.QoS, rule.Retain, []byte(req.Payload))
+	if err != nil {
+		count("failed")
+		log.WithFields(fields).WithError(err).Errorf("unable to publish")
+		http.Error(w, "unable to publish to mqtt", http.StatusBadGateway)
+		return
+	}
+	count("published")
+	log.WithFields(fields).Info("published")
+	w.Header().Add("content-type", "application/json")
+	err = json.NewEncoder(w).Encode(req)
+	if err != nil {
+		log.WithError(err).Infof("unable to encode publish")
+	}
+}
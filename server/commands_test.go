@@ -0,0 +1,151 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const allowlist = `{
+	"topics": [
+		{"topic": "door/bell/ring", "enum": ["ring"], "limit": 2, "interval": "1m"},
+		{"topic": "display/text", "pattern": "[a-z ]{1,16}", "qos": 1, "retain": true, "interval": "1s"}
+	]
+}`
+
+func newPublishServer(t *testing.T, env map[string]string) (*Server, *fakeClient) {
+	file := filepath.Join(t.TempDir(), "allowlist.json")
+	if err := ioutil.WriteFile(file, []byte(allowlist), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if env == nil {
+		env = map[string]string{}
+	}
+	env["PUBLISH_ALLOWLIST_FILE"] = file
+	s := newTestServer(t, env)
+	client := &fakeClient{}
+	s.mqttClient = client
+	return s, client
+}
+
+func TestPublish(t *testing.T) {
+	s, client := newPublishServer(t, nil)
+	for _, tc := range []struct {
+		name, body, remote string
+		status             int
+	}{
+		{"unlisted topic", `{"topic": "door/open", "payload": "1"}`, "192.0.2.1:1", http.StatusForbidden},
+		{"enum", `{"topic": "door/bell/ring", "payload": "ring"}`, "192.0.2.1:1", http.StatusOK},
+		{"not in enum", `{"topic": "door/bell/ring", "payload": "rin"}`, "192.0.2.1:1", http.StatusBadRequest},
+		{"pattern", `{"topic": "display/text", "payload": "hello world"}`, "192.0.2.1:1", http.StatusOK},
+		{"partial pattern match", `{"topic": "display/text", "payload": "hello World"}`, "192.0.2.2:1", http.StatusBadRequest},
+		{"pattern too long", `{"topic": "display/text", "payload": "abcdefghijklmnopq"}`, "192.0.2.2:1", http.StatusBadRequest},
+		{"invalid request", `{"topic": `, "192.0.2.1:1", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/publish", strings.NewReader(tc.body))
+		r.RemoteAddr = tc.remote
+		rec := httptest.NewRecorder()
+		s.handlePublish(rec, r)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.status, rec.Body)
+		}
+	}
+	want := []published{
+		{Topic: "door/bell/ring", Payload: "ring"},
+		{Topic: "display/text", QoS: 1, Retained: true, Payload: "hello world"},
+	}
+	if diff := cmp.Diff(want, client.messages()); diff != "" {
+		t.Errorf("invalid published messages. \n%s", diff)
+	}
+}
+
+func TestPublishRateLimit(t *testing.T) {
+	s, _ := newPublishServer(t, map[string]string{
+		"PUBLISH_CLIENT_HEADER":   "X-Forwarded-For",
+		"PUBLISH_TRUSTED_PROXIES": "1",
+	})
+	ring := func(forwarded string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/publish", strings.NewReader(`{"topic": "door/bell/ring", "payload": "ring"}`))
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", forwarded)
+		rec := httptest.NewRecorder()
+		s.handlePublish(rec, r)
+		return rec
+	}
+	for i, forwarded := range []string{"192.0.2.1", "198.51.100.1, 192.0.2.1"} {
+		if rec := ring(forwarded); rec.Code != http.StatusOK {
+			t.Fatalf("publish %d: status %d", i, rec.Code)
+		}
+	}
+	// spoofed entries before the trusted one are ignored
+	rec := ring("203.0.113.1, 192.0.2.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if retry, err := strconv.Atoi(rec.Header().Get("retry-after")); err != nil || retry < 59 || retry > 60 {
+		t.Errorf("retry-after %q, want 60", rec.Header().Get("retry-after"))
+	}
+	if rec := ring("192.0.2.2"); rec.Code != http.StatusOK {
+		t.Errorf("other client: status %d", rec.Code)
+	}
+}
+
+func TestClient(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		header    string
+		proxies   int
+		forwarded []string
+		want      string
+	}{
+		{"remote address", "", 1, []string{"192.0.2.1"}, "10.0.0.1"},
+		{"single proxy", "X-Forwarded-For", 1, []string{"198.51.100.1, 192.0.2.1"}, "192.0.2.1"},
+		{"two proxies", "X-Forwarded-For", 2, []string{"198.51.100.1, 192.0.2.1, 10.0.0.2"}, "192.0.2.1"},
+		{"multiple headers", "X-Forwarded-For", 2, []string{"198.51.100.1", "192.0.2.1", "10.0.0.2"}, "192.0.2.1"},
+		{"missing proxy", "X-Forwarded-For", 2, []string{"192.0.2.1"}, "10.0.0.1"},
+		{"missing header", "X-Forwarded-For", 1, nil, "10.0.0.1"},
+	} {
+		s := &Server{}
+		s.PublishClientHeader = tc.header
+		s.PublishTrustedProxies = tc.proxies
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/publish", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		for _, v := range tc.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if have := s.client(r); have != tc.want {
+			t.Errorf("%s: client %q, want %q", tc.name, have, tc.want)
+		}
+	}
+}
+
+func TestRateWindows(t *testing.T) {
+	rule := &commandRule{Topic: "door/bell/ring", Enum: []string{"ring"}, Interval: "1m"}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < maxRateWindows; i++ {
+		if _, ok := rule.allow(strconv.Itoa(i), start.Add(time.Duration(i)*time.Millisecond)); !ok {
+			t.Fatalf("client %d rate limited", i)
+		}
+	}
+	now := start.Add(30 * time.Second)
+	if wait, ok := rule.allow("new", now); ok || wait != 30*time.Second {
+		t.Errorf("new client beyond the limit: %s, %t", wait, ok)
+	}
+	if len(rule.windows) != maxRateWindows {
+		t.Errorf("%d windows, want %d", len(rule.windows), maxRateWindows)
+	}
+	// expired windows make room for new clients
+	if _, ok := rule.allow("new", start.Add(time.Minute)); !ok {
+		t.Errorf("new client rate limited after the oldest window expired")
+	}
+}
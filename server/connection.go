@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/b4ckspace/spacestatus/metrics"
+)
+
+// MqttState is the state of the mqtt connection
+type MqttState int32
+
+const (
+	StateConnecting MqttState = iota
+	StateConnected
+	StateSubscribed
+	StateLost
+)
+
+var mqttStates = []MqttState{StateConnecting, StateConnected, StateSubscribed, StateLost}
+
+func (m MqttState) String() string {
+	switch m {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateSubscribed:
+		return "subscribed"
+	case StateLost:
+		return "lost"
+	}
+	return fmt.Sprintf("MqttState(%d)", int32(m))
+}
+
+// MqttState returns the current state of the mqtt connection
+func (s *Server) MqttState() MqttState {
+	return MqttState(atomic.LoadInt32(&s.mqttState))
+}
+
+func (s *Server) setMqttState(state MqttState) {
+	atomic.StoreInt32(&s.mqttState, int32(state))
+}
+
+// registerMqttState reports the connection state as one gauge per state,
+// read on scrape so exactly one of them is 1
+func (s *Server) registerMqttState() {
+	for _, state := range mqttStates {
+		state := state
+		metrics.Func(fmt.Sprintf("spacestatus_mqtt_connection{state=%q}", state), func() int {
+			if s.MqttState() == state {
+				return 1
+			}
+			return 0
+		})
+	}
+}
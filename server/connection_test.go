@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/b4ckspace/spacestatus/metrics"
+)
+
+func TestMqttStateGauges(t *testing.T) {
+	mux := http.NewServeMux()
+	metrics.Register(mux)
+	scrape := func() map[string]string {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		gauges := map[string]string{}
+		for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+			if i := strings.LastIndex(line, " "); i > 0 && strings.HasPrefix(line, "spacestatus_mqtt_connection{") {
+				gauges[line[:i]] = line[i+1:]
+			}
+		}
+		return gauges
+	}
+
+	s := &Server{}
+	s.registerMqttState()
+	for _, state := range []MqttState{StateConnecting, StateConnected, StateSubscribed, StateLost, StateConnecting} {
+		s.setMqttState(state)
+		gauges := scrape()
+		if len(gauges) != len(mqttStates) {
+			t.Errorf("%s: gauges %v", state, gauges)
+		}
+		for _, st := range mqttStates {
+			want := "0"
+			if st == state {
+				want = "1"
+			}
+			if have := gauges[fmt.Sprintf("spacestatus_mqtt_connection{state=%q}", st)]; have != want {
+				t.Errorf("%s: %s gauge %q, want %q", state, st, have, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/events"
+	"github.com/b4ckspace/spacestatus/jsonpatch"
+	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/topic"
+)
+
+// document is the last rendered status document
+type document struct {
+	lock sync.Mutex
+	// data is the compact json, id the event it was published with
+	data    []byte
+	id      uint64
+	decoded interface{}
+}
+
+// render executes the status template
+func (s *Server) render(w io.Writer) error {
+	set := s.currentTemplates()
+	if set.template == nil {
+		return fmt.Errorf("no templates loaded")
+	}
+	return set.template.ExecuteTemplate(w, "status.json", nil)
+}
+
+// invalidate schedules a re-render of the status document
+func (s *Server) invalidate() {
+	select {
+	case s.rerender <- struct{}{}:
+	default:
+	}
+}
+
+// referenced reports whether topic t may be used by the templates
+func (s *Server) referenced(t string) bool {
+	set := s.currentTemplates()
+	if set.dynamic > 0 {
+		return true
+	}
+	for _, filter := range set.topics {
+		if filter == t || topic.Match(filter, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchDocument re-renders the status document when invalidated and
+// publishes it and a JSON patch against the previous one if it changed
+func (s *Server) watchDocument() {
+	for range s.rerender {
+		// coalesce bursts of updates
+		time.Sleep(s.DocumentDebounce)
+		if remaining, ready := s.readyIn(); !ready {
+			time.AfterFunc(remaining, s.invalidate)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		err := s.render(buf)
+		if err != nil {
+			log.WithError(err).Infof("unable to render template")
+			continue
+		}
+		compact := &bytes.Buffer{}
+		err = json.Compact(compact, buf.Bytes())
+		if err != nil {
+			log.WithError(err).Infof("rendered document is not valid json")
+			continue
+		}
+		s.updateDocument(compact.Bytes())
+	}
+}
+
+// updateDocument publishes data if it differs from the last document
+func (s *Server) updateDocument(data []byte) {
+	s.document.lock.Lock()
+	defer s.document.lock.Unlock()
+	if bytes.Equal(data, s.document.data) {
+		return
+	}
+	var decoded interface{}
+	_ = json.Unmarshal(data, &decoded)
+	if s.document.data != nil {
+		patch, err := json.Marshal(jsonpatch.Diff(s.document.decoded, decoded))
+		if err == nil {
+			s.documentEvents.Publish("patch", patch)
+		}
+	}
+	e := s.documentEvents.Publish("document", data)
+	s.document.data = data
+	s.document.decoded = decoded
+	s.document.id = e.ID
+	metrics.Count("spacestatus_document_changes")
+}
+
+// subscribeDocument subscribes to document changes and returns the
+// current document, events up to its id are already included in it
+func (s *Server) subscribeDocument(lastID uint64) (sub *events.Subscription, missed []events.Event, resumed bool, data []byte, id uint64) {
+	s.document.lock.Lock()
+	defer s.document.lock.Unlock()
+	sub, missed, resumed = s.documentEvents.Subscribe(lastID)
+	return sub, missed, resumed, s.document.data, s.document.id
+}
+
+// documentFrame is a websocket message of the document stream
+type documentFrame struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// handleDocumentStream streams the status document whenever it changes,
+// either in full or as JSON patches with mode=patch, over server-sent
+// events or a websocket
+func (s *Server) handleDocumentStream(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "document"
+	}
+	if mode != "document" && mode != "patch" {
+		http.Error(w, "invalid mode, expected document or patch", http.StatusBadRequest)
+		return
+	}
+	metrics.Count("spacestatus_api_requests{endpoint=\"status_stream\"}")
+	if websocket.IsWebSocketUpgrade(r) {
+		s.streamDocumentWebsocket(w, r, mode)
+		return
+	}
+	flusher, ok := prepareSSE(w)
+	if !ok {
+		return
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub, missed, resumed, data, id := s.subscribeDocument(lastID)
+	defer s.documentEvents.Unsubscribe(sub)
+
+	// a resumed client already has a document to apply patches to
+	base := resumed || data != nil
+	write := func(e events.Event) {
+		if e.ID > id && wantDocumentEvent(e, mode, base) {
+			writeEvent(w, e.Topic, e.ID, e.Data)
+			base = true
+		}
+	}
+	if resumed {
+		id = 0
+		for _, e := range missed {
+			write(e)
+		}
+	} else if data != nil {
+		writeEvent(w, "document", id, data)
+	}
+	s.streamSSE(w, r, flusher, sub, write)
+}
+
+// wantDocumentEvent reports whether a document stream in mode sends e,
+// patches need a base so the first document is always sent
+func wantDocumentEvent(e events.Event, mode string, base bool) bool {
+	if e.Topic == "document" {
+		return mode == "document" || !base
+	}
+	return e.Topic == mode && base
+}
+
+var documentUpgrader = websocket.Upgrader{
+	// the status document is public
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func (s *Server) streamDocumentWebsocket(w http.ResponseWriter, r *http.Request, mode string) {
+	conn, err := documentUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithError(err).Infof("unable to upgrade websocket")
+		return
+	}
+	defer conn.Close()
+	sub, _, _, data, id := s.subscribeDocument(0)
+	defer s.documentEvents.Unsubscribe(sub)
+
+	closed := make(chan struct{})
+	s.keepAlive(conn)
+	go func() {
+		// handle control frames, the stream is read-only
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	base := data != nil
+	if base {
+		err = conn.WriteJSON(documentFrame{ID: id, Type: "document", Data: data})
+		if err != nil {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(s.EventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		case e, ok := <-sub.C:
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			if e.ID <= id || !wantDocumentEvent(e, mode, base) {
+				continue
+			}
+			err = conn.WriteJSON(documentFrame{ID: e.ID, Type: e.Topic, Data: e.Data})
+			base = true
+		}
+		if err != nil {
+			return
+		}
+	}
+}
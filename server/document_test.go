@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sseEvent is a parsed server-sent event
+type sseEvent struct {
+	id    uint64
+	event string
+	data  string
+}
+
+// openSSE connects to the document stream and returns a function reading
+// the next event
+func openSSE(t *testing.T, url, lastID string) func() sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		r.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+	lines := bufio.NewScanner(resp.Body)
+	return func() sseEvent {
+		t.Helper()
+		var e sseEvent
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case line == "" && e.event != "":
+				return e
+			case strings.HasPrefix(line, "id: "):
+				e.id, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return e
+	}
+}
+
+// waitSubscribers waits until n clients are subscribed to the document
+func waitSubscribers(t *testing.T, s *Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for s.documentEvents.Subscribers() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("no subscriber")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDocumentStream(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		query string
+		// document before the client connects
+		initial bool
+		want    []sseEvent
+	}{
+		{"document", "", true, []sseEvent{
+			{event: "document", data: `{"a":1}`},
+			{event: "document", data: `{"a":2}`},
+		}},
+		{"document before first render", "?mode=document", false, []sseEvent{
+			{event: "document", data: `{"a":2}`},
+		}},
+		{"patch", "?mode=patch", true, []sseEvent{
+			{event: "document", data: `{"a":1}`},
+			{event: "patch", data: `[{"op":"replace","path":"/a","value":2}]`},
+		}},
+		{"patch before first render", "?mode=patch", false, []sseEvent{
+			{event: "document", data: `{"a":2}`},
+			{event: "patch", data: `[{"op":"replace","path":"/a","value":3}]`},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			if tc.initial {
+				s.updateDocument([]byte(`{"a":1}`))
+			}
+			ts := httptest.NewServer(http.HandlerFunc(s.handleDocumentStream))
+			// closed after the clients disconnected
+			t.Cleanup(ts.Close)
+			next := openSSE(t, ts.URL+tc.query, "")
+			waitSubscribers(t, s, 1)
+			s.updateDocument([]byte(`{"a":2}`))
+			s.updateDocument([]byte(`{"a":3}`))
+			for _, want := range tc.want {
+				if have := next(); have.event != want.event || have.data != want.data {
+					t.Errorf("got %s %s, want %s %s", have.event, have.data, want.event, want.data)
+				}
+			}
+		})
+	}
+}
+
+func TestDocumentStreamResume(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.updateDocument([]byte(`{"a":1}`))
+	first := s.document.id
+	s.updateDocument([]byte(`{"a":2}`))
+	s.updateDocument([]byte(`{"a":3}`))
+	ts := httptest.NewServer(http.HandlerFunc(s.handleDocumentStream))
+	t.Cleanup(ts.Close)
+
+	next := openSSE(t, ts.URL+"?mode=patch", strconv.FormatUint(first, 10))
+	for _, value := range []string{"2", "3"} {
+		e := next()
+		if e.event != "patch" || e.id <= first || !strings.Contains(e.data, `"value":`+value) {
+			t.Errorf("resumed: got %d %s %s, want patch to %s", e.id, e.event, e.data, value)
+		}
+	}
+
+	// without a known id the current document is sent
+	next = openSSE(t, ts.URL, "")
+	if e := next(); e.event != "document" || e.data != `{"a":3}` || e.id != s.document.id {
+		t.Errorf("new client: got %d %s %s", e.id, e.event, e.data)
+	}
+}
+
+func TestDocumentStreamWebsocket(t *testing.T) {
+	s := newTestServer(t, nil)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleDocumentStream))
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?mode=patch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitSubscribers(t, s, 1)
+	s.updateDocument([]byte(`{"a":1}`))
+	s.updateDocument([]byte(`{"a":2}`))
+	for _, want := range []documentFrame{
+		{Type: "document", Data: []byte(`{"a":1}`)},
+		{Type: "patch", Data: []byte(`[{"op":"replace","path":"/a","value":2}]`)},
+	} {
+		var frame documentFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.Type != want.Type || string(frame.Data) != string(want.Data) || frame.ID == 0 {
+			t.Errorf("got %d %s %s, want %s %s", frame.ID, frame.Type, frame.Data, want.Type, want.Data)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/cache"
+	"github.com/b4ckspace/spacestatus/events"
+	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/topic"
+)
+
+// topicEvent is the payload of topic change events
+type topicEvent struct {
+	Topic      string    `json:"topic"`
+	Value      string    `json:"value"`
+	ReceivedAt time.Time `json:"received_at"`
+	Retained   bool      `json:"retained"`
+	// Redacted events match TOPICS_API_REDACT and have their value removed
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+func newTopicEvent(e cache.Entry) topicEvent {
+	return topicEvent{
+		Topic:      e.Topic,
+		Value:      e.Value,
+		ReceivedAt: e.ReceivedAt,
+		Retained:   e.Retained,
+	}
+}
+
+// publish announces a cache update to the event subscribers
+func (s *Server) publish(e cache.Entry) {
+	data, err := json.Marshal(newTopicEvent(e))
+	if err != nil {
+		log.WithError(err).Infof("unable to encode event")
+		return
+	}
+	s.topicEvents.Publish(e.Topic, data)
+}
+
+// redactEvent removes the value from the event data of topics matching
+// TOPICS_API_REDACT
+func (s *Server) redactEvent(t string, data []byte) []byte {
+	if !topic.MatchAny(s.TopicsAPIRedact, t) {
+		return data
+	}
+	var e topicEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return []byte("{}")
+	}
+	e.Value = ""
+	e.Redacted = true
+	redacted, _ := json.Marshal(e)
+	return redacted
+}
+
+// eventsFilter returns a matcher for the topic query parameter, which must
+// be covered by EVENTS_TOPICS. Without the parameter all topics in
+// EVENTS_TOPICS are streamed.
+func (s *Server) eventsFilter(r *http.Request) (func(string) bool, int, error) {
+	filter := r.URL.Query().Get("topic")
+	if filter == "" {
+		return func(t string) bool {
+			return topic.MatchAny(s.EventsTopics, t)
+		}, 0, nil
+	}
+	if err := topic.Valid(filter); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	for _, allowed := range s.EventsTopics {
+		if topic.Covers(allowed, filter) {
+			return func(t string) bool {
+				return topic.Match(filter, t)
+			}, 0, nil
+		}
+	}
+	return nil, http.StatusForbidden, fmt.Errorf("topic filter %q is not allowed", filter)
+}
+
+// handleEvents streams topic changes matching the topic query parameter as
+// server-sent events, starting with a snapshot of the cache or the events
+// missed since Last-Event-ID
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if len(s.EventsTopics) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(s.eventsTokens) > 0 {
+		if _, ok := requireToken(w, r, s.eventsTokens); !ok {
+			return
+		}
+	}
+	match, status, err := s.eventsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	flusher, ok := prepareSSE(w)
+	if !ok {
+		return
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	sub, missed, resumed := s.topicEvents.Subscribe(lastID)
+	defer s.topicEvents.Unsubscribe(sub)
+	metrics.Count("spacestatus_api_requests{endpoint=\"events\"}")
+	metrics.Set("spacestatus_events_clients", s.topicEvents.Subscribers())
+	defer func() {
+		metrics.Set("spacestatus_events_clients", s.topicEvents.Subscribers())
+	}()
+
+	write := func(e events.Event) {
+		if match(e.Topic) {
+			writeEvent(w, "update", e.ID, s.redactEvent(e.Topic, e.Data))
+		}
+	}
+	if resumed {
+		for _, e := range missed {
+			write(e)
+		}
+	} else {
+		id := s.topicEvents.LastID()
+		s.each(func(e cache.Entry) bool {
+			if match(e.Topic) {
+				data, _ := json.Marshal(newTopicEvent(e))
+				writeEvent(w, "snapshot", 0, s.redactEvent(e.Topic, data))
+			}
+			return true
+		})
+		writeEvent(w, "ready", id, []byte("{}"))
+	}
+	s.streamSSE(w, r, flusher, sub, write)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventsAccess(t *testing.T) {
+	s := newTestServer(t, nil)
+	rec := httptest.NewRecorder()
+	s.handleEvents(rec, httptest.NewRequest(http.MethodGet, "/api/v1/events", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without EVENTS_TOPICS: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	s = newTestServer(t, map[string]string{
+		"EVENTS_TOPICS": "sensor/space/#",
+		"EVENTS_TOKENS": "display:secret",
+	})
+	for _, tc := range []struct {
+		name   string
+		query  string
+		token  string
+		status int
+	}{
+		{"missing token", "", "", http.StatusUnauthorized},
+		{"invalid token", "", "wrong", http.StatusUnauthorized},
+		{"invalid filter", "?topic=sensor/%23/space", "secret", http.StatusBadRequest},
+		{"uncovered filter", "?topic=sensor/%23", "secret", http.StatusForbidden},
+		{"other filter", "?topic=sensor/power/main/total", "secret", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/events"+tc.query, nil)
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		s.handleEvents(rec, r)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.status)
+		}
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	chdirRoot(t)
+	s := newTestServer(t, map[string]string{
+		"EVENTS_TOPICS":     "sensor/space/#",
+		"TOPICS_API_REDACT": "sensor/space/member/names",
+	})
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	s.Cache.Set("sensor/space/status", []byte("open"), true, 0)
+	s.Cache.Set("sensor/space/member/names", []byte("a, b"), true, 0)
+	s.Cache.Set("sensor/power/main/total", []byte("1234"), true, 0)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+	// next returns the data of the next event of the given type
+	next := func(event string) string {
+		t.Helper()
+		for lines.Scan() {
+			if lines.Text() != "event: "+event {
+				continue
+			}
+			lines.Scan()
+			return strings.TrimPrefix(lines.Text(), "data: ")
+		}
+		t.Fatalf("stream ended before %s event: %v", event, lines.Err())
+		return ""
+	}
+
+	joined := next("snapshot") + "\n" + next("snapshot")
+	if !strings.Contains(joined, `"value":"open"`) {
+		t.Errorf("snapshot without status: %s", joined)
+	}
+	if strings.Contains(joined, "a, b") || !strings.Contains(joined, `"redacted":true`) {
+		t.Errorf("snapshot not redacted: %s", joined)
+	}
+	next("ready")
+
+	s.update("sensor/power/main/total", []byte("2345"), false, 0)
+	s.update("sensor/space/member/names", []byte("c"), false, 0)
+	data := next("update")
+	if !strings.Contains(data, `"topic":"sensor/space/member/names"`) || !strings.Contains(data, `"value":""`) {
+		t.Errorf("invalid update: %s", data)
+	}
+}
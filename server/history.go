@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/history"
+	"github.com/b4ckspace/spacestatus/metrics"
+)
+
+type historyResponse struct {
+	Topic     string           `json:"topic"`
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Step      string           `json:"step,omitempty"`
+	Aggregate string           `json:"aggregate,omitempty"`
+	Samples   []history.Sample `json:"samples"`
+}
+
+// handleHistory serves the recorded samples of a topic as json or csv
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	metrics.Count("spacestatus_api_requests{endpoint=\"history\"}")
+	q := r.URL.Query()
+	resp := historyResponse{
+		Topic: q.Get("topic"),
+		To:    time.Now(),
+	}
+	if resp.Topic == "" {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if v := q.Get("to"); v != "" {
+		resp.To, err = parseTime(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	resp.From = resp.To.Add(-s.History.MaxAge())
+	if v := q.Get("from"); v != "" {
+		resp.From, err = parseTime(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if resp.From.After(resp.To) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+	if s.TSDB != nil && resp.From.Before(time.Now().Add(-s.History.MaxAge())) {
+		// beyond the in-memory history
+		resp.Samples, err = s.storedSamples(resp.Topic, resp.From, resp.To, q.Get("agg"))
+		if err != nil {
+			log.WithError(err).Errorf("unable to query tsdb")
+			http.Error(w, "unable to query history", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		resp.Samples = s.History.Samples(resp.Topic, resp.From, resp.To)
+	}
+
+	if v := q.Get("step"); v != "" {
+		step, err := time.ParseDuration(v)
+		if err != nil || step <= 0 {
+			http.Error(w, fmt.Sprintf("invalid step %q", v), http.StatusBadRequest)
+			return
+		}
+		resp.Step = step.String()
+		resp.Aggregate = q.Get("agg")
+		if resp.Aggregate == "" {
+			resp.Aggregate = "avg"
+		}
+		aggregate, found := history.Aggregates[resp.Aggregate]
+		if !found {
+			http.Error(w, fmt.Sprintf("invalid agg %q, expected avg, min or max", resp.Aggregate), http.StatusBadRequest)
+			return
+		}
+		resp.Samples = history.Downsample(resp.Samples, resp.From, step, aggregate)
+	}
+
+	if q.Get("format") == "csv" || (q.Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/csv")) {
+		w.Header().Add("content-type", "text/csv")
+		c := csv.NewWriter(w)
+		_ = c.Write([]string{"time", "value"})
+		for _, sample := range resp.Samples {
+			_ = c.Write([]string{
+				sample.Time.Format(time.RFC3339),
+				strconv.FormatFloat(sample.Value, 'f', -1, 64),
+			})
+		}
+		c.Flush()
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.WithError(err).Infof("unable to encode history")
+	}
+}
+
+// parseTime parses RFC3339 times and unix timestamps
+func parseTime(v string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/b4ckspace/spacestatus/history"
+)
+
+func TestHistoryTopics(t *testing.T) {
+	chdirRoot(t)
+	s := newTestServer(t, nil)
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	s.update("sensor/power/main/total", []byte("1234"), false, 0)
+	s.update("sensor/power/other", []byte("1"), false, 0)
+	if samples := s.History.Window("sensor/power/main/total", time.Minute); len(samples) != 1 {
+		t.Errorf("template topic: %d samples, want 1", len(samples))
+	}
+	if samples := s.History.Window("sensor/power/other", time.Minute); len(samples) != 0 {
+		t.Errorf("unreferenced topic: %d samples, want 0", len(samples))
+	}
+
+	s = newTestServer(t, map[string]string{"HISTORY_TOPICS": "sensor/power/#"})
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	s.update("sensor/power/other", []byte("1"), false, 0)
+	if samples := s.History.Window("sensor/power/other", time.Minute); len(samples) != 1 {
+		t.Errorf("configured topic: %d samples, want 1", len(samples))
+	}
+}
+
+func TestTsdbTopics(t *testing.T) {
+	chdirRoot(t)
+	s := newTestServer(t, nil)
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	for topic, want := range map[string]bool{
+		"sensor/power/main/total":     true,
+		"sensor/space/member/present": true,
+		"sensor/power/other":          false,
+	} {
+		if got := s.tsdbWanted(topic); got != want {
+			t.Errorf("default %s: got %v, want %v", topic, got, want)
+		}
+	}
+
+	s = newTestServer(t, map[string]string{"TSDB_TOPICS": "sensor/power/#"})
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.tsdbWanted("sensor/power/other") || s.tsdbWanted("sensor/space/member/present") {
+		t.Errorf("configured topics not applied")
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	s := newTestServer(t, map[string]string{"TSDB_DIR": t.TempDir()})
+	if err := s.OpenTSDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.TSDB.Close()
+	})
+	const topic = "sensor/power/main/total"
+	base := time.Now().Truncate(time.Minute).Add(-30 * time.Minute)
+	for i, value := range []float64{1, 2, 3, 4} {
+		if err := s.History.Add(topic, base.Add(time.Duration(i)*time.Minute), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored := base.Add(-30 * time.Hour)
+	if err := s.TSDB.Add(topic, stored, 5); err != nil {
+		t.Fatal(err)
+	}
+	unix := func(t time.Time) string {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	get := func(query, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/history?"+query, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		s.handleHistory(rec, r)
+		return rec
+	}
+
+	for _, query := range []string{
+		"",
+		"topic=" + topic + "&from=yesterday",
+		"topic=" + topic + "&to=1.5",
+		"topic=" + topic + "&from=" + unix(base) + "&to=" + unix(base.Add(-time.Second)),
+		"topic=" + topic + "&step=-1m",
+		"topic=" + topic + "&step=1m&agg=median",
+	} {
+		if rec := get(query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		want  []history.Sample
+	}{
+		{
+			name:  "range",
+			query: "&from=" + base.Add(time.Minute).Format(time.RFC3339) + "&to=" + unix(base.Add(2*time.Minute)),
+			want: []history.Sample{
+				{Time: base.Add(time.Minute), Value: 2},
+				{Time: base.Add(2 * time.Minute), Value: 3},
+			},
+		},
+		{
+			name:  "step",
+			query: "&from=" + unix(base) + "&step=2m&agg=max",
+			want: []history.Sample{
+				{Time: base, Value: 2},
+				{Time: base.Add(2 * time.Minute), Value: 4},
+			},
+		},
+		{
+			name:  "tsdb",
+			query: "&from=" + unix(stored.Add(-time.Hour)) + "&to=" + unix(stored.Add(time.Hour)),
+			want:  []history.Sample{{Time: stored, Value: 5}},
+		},
+	} {
+		rec := get("topic="+topic+tc.query, "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tc.name, rec.Code, rec.Body)
+			continue
+		}
+		var resp historyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tc.want, resp.Samples); diff != "" {
+			t.Errorf("%s: invalid samples. \n%s", tc.name, diff)
+		}
+	}
+
+	want := "time,value\n" +
+		base.Add(time.Minute).Format(time.RFC3339) + ",2\n" +
+		base.Add(2*time.Minute).Format(time.RFC3339) + ",3\n"
+	query := "topic=" + topic + "&from=" + unix(base.Add(time.Minute)) + "&to=" + unix(base.Add(2*time.Minute))
+	for _, rec := range []*httptest.ResponseRecorder{
+		get(query+"&format=csv", ""),
+		get(query, "text/csv"),
+	} {
+		if rec.Header().Get("content-type") != "text/csv" || rec.Body.String() != want {
+			t.Errorf("csv: %s \n%s, want \n%s", rec.Header().Get("content-type"), rec.Body, want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/metrics"
+)
+
+// spaceAPIState holds the fields of the status document published as
+// derived topics
+type spaceAPIState struct {
+	Open  *bool `json:"open"`
+	State struct {
+		Open *bool `json:"open"`
+	} `json:"state"`
+	Sensors struct {
+		PeopleNowPresent []struct {
+			Value *float64 `json:"value"`
+		} `json:"people_now_present"`
+	} `json:"sensors"`
+}
+
+// statusMessages returns the retained messages to publish for a rendered
+// status document by topic
+func (s *Server) statusMessages(document []byte) map[string][]byte {
+	messages := map[string][]byte{}
+	if s.StatusPublishTopic != "" {
+		messages[s.StatusPublishTopic] = document
+	}
+	if s.StatusPublishPrefix == "" {
+		return messages
+	}
+	var state spaceAPIState
+	err := json.Unmarshal(document, &state)
+	if err != nil {
+		log.WithError(err).Infof("unable to decode status document")
+		return messages
+	}
+	open := state.State.Open
+	if open == nil {
+		// deprecated before SpaceAPI 0.13
+		open = state.Open
+	}
+	if open != nil {
+		messages[s.StatusPublishPrefix+"/open"] = []byte(strconv.FormatBool(*open))
+	}
+	people, found := 0.0, false
+	for _, sensor := range state.Sensors.PeopleNowPresent {
+		if sensor.Value != nil {
+			people += *sensor.Value
+			found = true
+		}
+	}
+	if found {
+		messages[s.StatusPublishPrefix+"/people"] = []byte(strconv.FormatFloat(people, 'f', -1, 64))
+	}
+	return messages
+}
+
+// publishStatus publishes the rendered status document and the derived
+// topics as retained messages when they change, at most once per
+// STATUS_PUBLISH_INTERVAL. After a reconnect all of them are published
+// again, as a failover broker may not have the retained messages.
+func (s *Server) publishStatus() {
+	sub, _, _, pending, id := s.subscribeDocument(0)
+	published := map[string][]byte{}
+	var next time.Time
+	var wake <-chan time.Time
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped as slow subscriber, continue with the current document
+				sub, _, _, pending, id = s.subscribeDocument(0)
+				break
+			}
+			if e.Topic == "document" && e.ID > id {
+				pending = e.Data
+			}
+		case <-wake:
+			wake = nil
+		case <-s.republish:
+			published = map[string][]byte{}
+			if pending == nil {
+				s.document.lock.Lock()
+				pending = s.document.data
+				s.document.lock.Unlock()
+			}
+		}
+		if pending == nil || wake != nil {
+			continue
+		}
+		if wait := time.Until(next); wait > 0 {
+			wake = time.After(wait)
+			continue
+		}
+		failed := false
+		for t, payload := range s.statusMessages(pending) {
+			if bytes.Equal(published[t], payload) {
+				continue
+			}
+			err := s.publishMessage(t, s.StatusPublishQos, true, payload)
+			if err != nil {
+				metrics.Count("spacestatus_status_publish{result=\"failed\"}")
+				log.WithError(err).WithField("topic", t).Errorf("unable to publish status")
+				failed = true
+				continue
+			}
+			metrics.Count("spacestatus_status_publish{result=\"published\"}")
+			published[t] = payload
+		}
+		next = time.Now().Add(s.StatusPublishInterval)
+		if failed {
+			// retry with the latest document
+			wake = time.After(s.StatusPublishInterval)
+			continue
+		}
+		pending = nil
+	}
+}
+
+// publishMessage publishes a message to the broker
+func (s *Server) publishMessage(topic string, qos byte, retained bool, payload []byte) error {
+	t := s.mqttClient.Publish(topic, qos, retained, payload)
+	if !t.WaitTimeout(s.MqttConnectTimeout) {
+		return fmt.Errorf("timeout after %s", s.MqttConnectTimeout)
+	}
+	return t.Error()
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStatusMessages(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"STATUS_PUBLISH_TOPIC":  "spacestatus/json",
+		"STATUS_PUBLISH_PREFIX": "spacestatus",
+	})
+	for _, tc := range []struct {
+		name     string
+		document string
+		want     map[string]string
+	}{
+		{
+			name:     "state.open",
+			document: `{"state": {"open": true}, "sensors": {"people_now_present": [{"value": 3}, {"value": 1.5}]}}`,
+			want: map[string]string{
+				"spacestatus/open":   "true",
+				"spacestatus/people": "4.5",
+			},
+		},
+		{
+			name:     "legacy open",
+			document: `{"open": false, "sensors": {"people_now_present": [{"location": "lab"}]}}`,
+			want: map[string]string{
+				"spacestatus/open": "false",
+			},
+		},
+		{
+			name:     "state.open before legacy open",
+			document: `{"open": true, "state": {"open": false}}`,
+			want: map[string]string{
+				"spacestatus/open": "false",
+			},
+		},
+		{
+			name:     "invalid",
+			document: `{"state": `,
+			want:     map[string]string{},
+		},
+	} {
+		have := map[string]string{}
+		for topic, payload := range s.statusMessages([]byte(tc.document)) {
+			if topic == "spacestatus/json" {
+				if string(payload) != tc.document {
+					t.Errorf("%s: document %s, want %s", tc.name, payload, tc.document)
+				}
+				continue
+			}
+			have[topic] = string(payload)
+		}
+		if diff := cmp.Diff(tc.want, have); diff != "" {
+			t.Errorf("%s: invalid messages. \n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestPublishStatusReconnect(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"STATUS_PUBLISH_TOPIC":    "spacestatus/json",
+		"STATUS_PUBLISH_INTERVAL": "1ms",
+	})
+	client := &fakeClient{}
+	s.mqttClient = client
+	s.updateDocument([]byte(`{"state":{"open":true}}`))
+	go s.publishStatus()
+	// wait for n publishes of the document
+	wait := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for len(client.messages()) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d messages published, want %d", len(client.messages()), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// the initial connect
+	s.republish <- struct{}{}
+	wait(1)
+	s.republish <- struct{}{}
+	wait(2)
+	want := published{"spacestatus/json", 1, true, `{"state":{"open":true}}`}
+	for _, m := range client.messages() {
+		if m != want {
+			t.Errorf("got %+v, want %+v", m, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// readiness tracks whether the cache has settled after the initial subscription
+type readiness struct {
+	lock         sync.Mutex
+	subscribedAt time.Time
+	ready        bool
+}
+
+// markSubscribed starts the settle timeout on the first subscription
+func (r *readiness) markSubscribed() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.subscribedAt.IsZero() {
+		r.subscribedAt = time.Now()
+	}
+}
+
+// Ready reports whether the required topics have been received or the
+// settle timeout has elapsed after the initial subscription
+func (s *Server) Ready() bool {
+	_, ready := s.readyIn()
+	return ready
+}
+
+// readyIn returns whether the server is ready, and if not, the time
+// until the settle timeout elapses
+func (s *Server) readyIn() (time.Duration, bool) {
+	r := &s.readiness
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.ready {
+		return 0, true
+	}
+	if r.subscribedAt.IsZero() {
+		return s.ReadyTimeout, false
+	}
+	remaining := s.ReadyTimeout - time.Since(r.subscribedAt)
+	if remaining > 0 && !s.requiredTopicsSeen() {
+		return remaining, false
+	}
+	r.ready = true
+	log.WithField("timeout", remaining <= 0).Info("ready")
+	return 0, true
+}
+
+// requiredTopicsSeen reports whether all READY_TOPICS have been received
+// since startup, values restored from a snapshot do not count. Without
+// READY_TOPICS only the timeout applies.
+func (s *Server) requiredTopicsSeen() bool {
+	if len(s.ReadyTopics) == 0 {
+		return false
+	}
+	for _, t := range s.ReadyTopics {
+		e, found := s.Cache.Get(t)
+		if !found || e.Restored {
+			return false
+		}
+	}
+	return true
+}
+
+// notReady answers with 503 until the server is ready
+func (s *Server) notReady(w http.ResponseWriter) bool {
+	remaining, ready := s.readyIn()
+	if ready {
+		return false
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(remaining.Seconds()))))
+	http.Error(w, "not ready", http.StatusServiceUnavailable)
+	return true
+}
+
+// handleHealthz reports liveness
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports readiness including the mqtt connection state
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	state := s.MqttState()
+	if state != StateSubscribed || !s.Ready() {
+		http.Error(w, fmt.Sprintf("not ready, mqtt %s", state), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ready, mqtt %s\n", state)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// notReadyIn returns whether the request was rejected and its Retry-After
+func notReadyIn(s *Server) (bool, string) {
+	w := httptest.NewRecorder()
+	rejected := s.notReady(w)
+	if rejected && w.Code != http.StatusServiceUnavailable {
+		return rejected, "unexpected status"
+	}
+	return rejected, w.Header().Get("Retry-After")
+}
+
+func TestReadyTimeoutWithoutTopics(t *testing.T) {
+	s := newTestServer(t, map[string]string{"READY_TIMEOUT": "1h"})
+	s.Cache.Set("sensor/space/status", []byte("open"), false, 0)
+
+	if rejected, retry := notReadyIn(s); !rejected || retry != "3600" {
+		t.Errorf("before subscription: rejected %v, Retry-After %q, want true, 3600", rejected, retry)
+	}
+	s.readiness.markSubscribed()
+	s.readiness.subscribedAt = time.Now().Add(-30 * time.Minute)
+	if rejected, retry := notReadyIn(s); !rejected || retry != "1800" {
+		t.Errorf("within timeout: rejected %v, Retry-After %q, want true, 1800", rejected, retry)
+	}
+	s.readiness.subscribedAt = time.Now().Add(-time.Hour)
+	if rejected, _ := notReadyIn(s); rejected {
+		t.Errorf("after timeout: rejected, want ready")
+	}
+}
+
+func TestReadyTopics(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"READY_TIMEOUT": "1h",
+		"READY_TOPICS":  "sensor/space/status,sensor/space/member/present",
+	})
+	s.readiness.markSubscribed()
+	s.Cache.Set("sensor/space/status", []byte("open"), false, 0)
+	if rejected, _ := notReadyIn(s); !rejected {
+		t.Errorf("with a missing topic: ready, want rejected")
+	}
+	s.Cache.Set("sensor/space/member/present", []byte("3"), false, 0)
+	if rejected, _ := notReadyIn(s); rejected {
+		t.Errorf("with all topics: rejected, want ready")
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var lastChange = regexp.MustCompile(`"lastchange": [0-9]+`)
+
+func TestRenderMissingTopic(t *testing.T) {
+	chdirRoot(t)
+	s := newTestServer(t, nil)
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	for topic, value := range map[string]string{
+		"sensor/space/member/names":           "a, b, c, d",
+		"sensor/space/member/present":         "4",
+		"sensor/space/member/count":           "30",
+		"sensor/temperature/hackcenter/shelf": "21.3",
+		"sensor/power/main/L1":                "123",
+		"sensor/power/main/L2":                "234",
+		"sensor/power/main/L3":                "345",
+		"sensor/power/main/total":             "1234",
+		"sensor/space/status":                 "closed",
+		"sensor/radiation/cpm":                "42",
+		"sensor/radiation/uSv":                "0.23",
+	} {
+		s.Cache.Set(topic, []byte(value), false, 0)
+	}
+	have := &bytes.Buffer{}
+	if err := s.render(have); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("testdata/status-missing.json")
+	if err != nil {
+		t.Fatalf("Unable to load want: %v", err)
+	}
+	// the time of the last change is not reproducible
+	rendered := lastChange.ReplaceAllString(have.String(), `"lastchange": 0`)
+	if diff := cmp.Diff(strings.Split(string(want), "\n"), strings.Split(rendered, "\n")); diff != "" {
+		t.Errorf("Invalid output. \n%s", diff)
+	}
+}
@@ -1,9 +1,16 @@
 package server
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -11,24 +18,129 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/b4ckspace/spacestatus/cache"
+	"github.com/b4ckspace/spacestatus/events"
 	"github.com/b4ckspace/spacestatus/filters"
+	"github.com/b4ckspace/spacestatus/history"
 	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/stats"
+	"github.com/b4ckspace/spacestatus/topic"
+	"github.com/b4ckspace/spacestatus/tsdb"
 )
 
 type Server struct {
-	MqttURL      *url.URL `envconfig:"MQTT_URL" default:"tcp://mqtt:1883"`
-	MqttClientId string   `envconfig:"MQTT_CLIENT_ID" default:"go-mqtt-spacestatus-dev"`
-	Listen       string   `envconfig:"LISTEN" default:":8080"`
-	Debug        bool     `envconfig:"DEBUG"`
+	MqttURL                []string                 `envconfig:"MQTT_URL" default:"tcp://mqtt:1883"`
+	MqttOrder              string                   `envconfig:"MQTT_ORDER" default:"ordered"`
+	MqttConnectTimeout     time.Duration            `envconfig:"MQTT_CONNECT_TIMEOUT" default:"30s"`
+	MqttMaxReconnect       time.Duration            `envconfig:"MQTT_MAX_RECONNECT_INTERVAL" default:"1m"`
+	MqttClientId           string                   `envconfig:"MQTT_CLIENT_ID" default:"go-mqtt-spacestatus-dev"`
+	MqttPersistentSession  bool                     `envconfig:"MQTT_PERSISTENT_SESSION"`
+	MqttUsername           string                   `envconfig:"MQTT_USERNAME"`
+	MqttUsernameFile       string                   `envconfig:"MQTT_USERNAME_FILE"`
+	MqttPassword           string                   `envconfig:"MQTT_PASSWORD"`
+	MqttPasswordFile       string                   `envconfig:"MQTT_PASSWORD_FILE"`
+	MqttCAFile             string                   `envconfig:"MQTT_CA_FILE"`
+	MqttCertFile           string                   `envconfig:"MQTT_CERT_FILE"`
+	MqttKeyFile            string                   `envconfig:"MQTT_KEY_FILE"`
+	MqttInsecureSkipVerify bool                     `envconfig:"MQTT_INSECURE_SKIP_VERIFY"`
+	MqttTopics             map[string]byte          `envconfig:"MQTT_TOPICS" default:"#:0"`
+	MqttExclude            []string                 `envconfig:"MQTT_EXCLUDE"`
+	MqttTopicsFromTemplate bool                     `envconfig:"MQTT_TOPICS_FROM_TEMPLATE"`
+	MqttTemplateFallback   string                   `envconfig:"MQTT_TEMPLATE_FALLBACK"`
+	TemplatePollInterval   time.Duration            `envconfig:"TEMPLATE_POLL_INTERVAL"`
+	TopicMaxAge            map[string]time.Duration `envconfig:"TOPIC_MAX_AGE"`
+	ReadyTopics            []string                 `envconfig:"READY_TOPICS"`
+	ReadyTimeout           time.Duration            `envconfig:"READY_TIMEOUT" default:"10s"`
+	HistoryTopics          []string                 `envconfig:"HISTORY_TOPICS"`
+	HistorySize            int                      `envconfig:"HISTORY_SIZE" default:"8640"`
+	HistoryMaxAge          time.Duration            `envconfig:"HISTORY_MAX_AGE" default:"24h"`
+	HistoryInterval        time.Duration            `envconfig:"HISTORY_INTERVAL" default:"10s"`
+	HistoryMaxTopics       int                      `envconfig:"HISTORY_MAX_TOPICS" default:"64"`
+	TsdbDir                string                   `envconfig:"TSDB_DIR"`
+	TsdbTopics             []string                 `envconfig:"TSDB_TOPICS"`
+	TsdbRawInterval        time.Duration            `envconfig:"TSDB_RAW_INTERVAL" default:"1m"`
+	TsdbRawRetention       time.Duration            `envconfig:"TSDB_RAW_RETENTION" default:"48h"`
+	TsdbHourlyRetention    time.Duration            `envconfig:"TSDB_HOURLY_RETENTION" default:"2160h"`
+	TsdbDailyRetention     time.Duration            `envconfig:"TSDB_DAILY_RETENTION" default:"17520h"`
+	TsdbCompactInterval    time.Duration            `envconfig:"TSDB_COMPACT_INTERVAL" default:"1h"`
+	StatsStatusTopic       string                   `envconfig:"STATS_STATUS_TOPIC" default:"sensor/space/status"`
+	StatsPeopleTopic       string                   `envconfig:"STATS_PEOPLE_TOPIC" default:"sensor/space/member/present"`
+	StatsLookback          time.Duration            `envconfig:"STATS_LOOKBACK" default:"2016h"`
+	StatsTimezone          string                   `envconfig:"STATS_TIMEZONE" default:"Local"`
+	EventsReplay           int                      `envconfig:"EVENTS_REPLAY" default:"256"`
+	EventsBuffer           int                      `envconfig:"EVENTS_BUFFER" default:"64"`
+	EventsHeartbeat        time.Duration            `envconfig:"EVENTS_HEARTBEAT" default:"15s"`
+	EventsTopics           []string                 `envconfig:"EVENTS_TOPICS"`
+	EventsTokens           map[string]string        `envconfig:"EVENTS_TOKENS"`
+	EventsTokensFile       string                   `envconfig:"EVENTS_TOKENS_FILE"`
+	DocumentDebounce       time.Duration            `envconfig:"DOCUMENT_DEBOUNCE" default:"200ms"`
+	StatusPublishTopic     string                   `envconfig:"STATUS_PUBLISH_TOPIC"`
+	StatusPublishPrefix    string                   `envconfig:"STATUS_PUBLISH_PREFIX"`
+	StatusPublishInterval  time.Duration            `envconfig:"STATUS_PUBLISH_INTERVAL" default:"5s"`
+	StatusPublishQos       byte                     `envconfig:"STATUS_PUBLISH_QOS" default:"1"`
+	StateTopic             string                   `envconfig:"STATE_TOPIC" default:"sensor/space/status"`
+	StateTokens            map[string]string        `envconfig:"STATE_TOKENS"`
+	StateTokensFile        string                   `envconfig:"STATE_TOKENS_FILE"`
+	StateAuditFile         string                   `envconfig:"STATE_AUDIT_FILE"`
+	IngestTokens           map[string]string        `envconfig:"INGEST_TOKENS"`
+	IngestTokensFile       string                   `envconfig:"INGEST_TOKENS_FILE"`
+	IngestPermissions      map[string]string        `envconfig:"INGEST_PERMISSIONS"`
+	IngestMaxBytes         int64                    `envconfig:"INGEST_MAX_BYTES" default:"65536"`
+	IngestPublish          bool                     `envconfig:"INGEST_PUBLISH"`
+	IngestPublishQos       byte                     `envconfig:"INGEST_PUBLISH_QOS" default:"1"`
+	TopicsAPITokens        map[string]string        `envconfig:"TOPICS_API_TOKENS"`
+	TopicsAPITokensFile    string                   `envconfig:"TOPICS_API_TOKENS_FILE"`
+	TopicsAPIRedact        []string                 `envconfig:"TOPICS_API_REDACT" default:"sensor/space/member/names"`
+	PublishAllowlistFile   string                   `envconfig:"PUBLISH_ALLOWLIST_FILE"`
+	PublishClientHeader    string                   `envconfig:"PUBLISH_CLIENT_HEADER"`
+	PublishTrustedProxies  int                      `envconfig:"PUBLISH_TRUSTED_PROXIES" default:"1"`
+	WsTopics               []string                 `envconfig:"WS_TOPICS"`
+	WsMaxSubscriptions     int                      `envconfig:"WS_MAX_SUBSCRIPTIONS" default:"16"`
+	WsOrigins              []string                 `envconfig:"WS_ORIGINS"`
+	CacheFile              string                   `envconfig:"CACHE_FILE"`
+	CacheSnapshotInterval  time.Duration            `envconfig:"CACHE_SNAPSHOT_INTERVAL" default:"1m"`
+	Listen                 string                   `envconfig:"LISTEN" default:":8080"`
+	Debug                  bool                     `envconfig:"DEBUG"`
 
-	Cache *sync.Map
+	// MqttURLs are the parsed MQTT_URL servers
+	MqttURLs []*url.URL `ignored:"true"`
 
-	mux      *http.ServeMux
-	template *template.Template
+	Cache   *cache.Cache
+	History *history.Store
+	TSDB    *tsdb.DB
+	Stats   *stats.Tracker
+
+	mqttTLS           *tls.Config
+	mqttClient        mqtt.Client
+	brokers           brokers
+	mqttState         int32
+	subscribed        chan error
+	readiness         readiness
+	samples           chan sample
+	statsLocation     *time.Location
+	topicEvents       *events.Hub
+	documentEvents    *events.Hub
+	document          document
+	rerender          chan struct{}
+	republish         chan struct{}
+	wsConnections     int32
+	stateTokens       map[string]string
+	ingestTokens      map[string]string
+	topicsTokens      map[string]string
+	eventsTokens      map[string]string
+	commands          map[string]*commandRule
+	ingestPermissions map[string][]string
+	overrides         overrides
+	mux               *http.ServeMux
+	topicsLock        sync.RWMutex
+	templates         atomic.Value
+	templateReload    templateReload
 }
 
 func NewServer() (s *Server, err error) {
-	s = &Server{}
+	s = &Server{
+		Cache: cache.New(),
+	}
 	s.mux = http.NewServeMux()
 	err = envconfig.Process("", s)
 	if err != nil {
@@ -37,67 +149,469 @@ func NewServer() (s *Server, err error) {
 	if s.Debug {
 		log.SetLevel(log.DebugLevel)
 	}
+	for _, raw := range s.MqttURL {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MQTT_URL: %w", err)
+		}
+		s.MqttURLs = append(s.MqttURLs, u)
+	}
+	if len(s.MqttURLs) == 0 {
+		return nil, fmt.Errorf("MQTT_URL must not be empty")
+	}
+	if s.MqttOrder != "ordered" && s.MqttOrder != "random" {
+		return nil, fmt.Errorf("invalid MQTT_ORDER %q, expected ordered or random", s.MqttOrder)
+	}
+	for filter, qos := range s.MqttTopics {
+		if err := topic.Valid(filter); err != nil {
+			return nil, err
+		}
+		if qos > 2 {
+			return nil, fmt.Errorf("invalid qos %d for topic filter %q", qos, filter)
+		}
+	}
+	for _, filter := range s.MqttExclude {
+		if err := topic.Valid(filter); err != nil {
+			return nil, err
+		}
+	}
+	if s.MqttTemplateFallback != "" {
+		if err := topic.Valid(s.MqttTemplateFallback); err != nil {
+			return nil, err
+		}
+	}
+	for filter := range s.TopicMaxAge {
+		if err := topic.Valid(filter); err != nil {
+			return nil, err
+		}
+	}
+	for _, filters := range [][]string{s.HistoryTopics, s.TsdbTopics, s.EventsTopics, s.WsTopics, s.TopicsAPIRedact} {
+		for _, filter := range filters {
+			if err := topic.Valid(filter); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s.TsdbCompactInterval <= 0 {
+		return nil, fmt.Errorf("TSDB_COMPACT_INTERVAL must be positive")
+	}
+	if s.HistorySize <= 0 || s.HistoryMaxTopics < 0 {
+		return nil, fmt.Errorf("HISTORY_SIZE must be positive and HISTORY_MAX_TOPICS must not be negative")
+	}
+	s.History = history.New(s.HistorySize, s.HistoryMaxAge, s.HistoryInterval, s.HistoryMaxTopics)
+	s.statsLocation, err = time.LoadLocation(s.StatsTimezone)
+	if err != nil {
+		return nil, err
+	}
+	s.Stats = stats.NewTracker(s.StatsLookback)
+	if s.EventsReplay < 0 || s.EventsBuffer <= 0 || s.EventsHeartbeat <= 0 {
+		return nil, fmt.Errorf("EVENTS_BUFFER and EVENTS_HEARTBEAT must be positive, EVENTS_REPLAY must not be negative")
+	}
+	s.topicEvents = events.NewHub(s.EventsReplay, s.EventsBuffer)
+	s.topicEvents.OnDrop = func() {
+		metrics.Count("spacestatus_events_dropped{stream=\"topics\"}")
+	}
+	s.documentEvents = events.NewHub(s.EventsReplay, s.EventsBuffer)
+	s.documentEvents.OnDrop = func() {
+		metrics.Count("spacestatus_events_dropped{stream=\"document\"}")
+	}
+	s.rerender = make(chan struct{}, 1)
+	s.republish = make(chan struct{}, 1)
+	for _, t := range []string{s.StatusPublishTopic, s.StatusPublishPrefix} {
+		if strings.ContainsAny(t, "+#") {
+			return nil, fmt.Errorf("invalid status publish topic %q: wildcards are not allowed", t)
+		}
+	}
+	if s.StatusPublishInterval <= 0 || s.StatusPublishQos > 2 {
+		return nil, fmt.Errorf("STATUS_PUBLISH_INTERVAL must be positive and STATUS_PUBLISH_QOS at most 2")
+	}
+	s.stateTokens, err = loadTokens("STATE_TOKENS", s.StateTokens, s.StateTokensFile)
+	if err != nil {
+		return nil, err
+	}
+	s.topicsTokens, err = loadTokens("TOPICS_API_TOKENS", s.TopicsAPITokens, s.TopicsAPITokensFile)
+	if err != nil {
+		return nil, err
+	}
+	s.eventsTokens, err = loadTokens("EVENTS_TOKENS", s.EventsTokens, s.EventsTokensFile)
+	if err != nil {
+		return nil, err
+	}
+	s.ingestTokens, err = loadTokens("INGEST_TOKENS", s.IngestTokens, s.IngestTokensFile)
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadIngestPermissions()
+	if err != nil {
+		return nil, err
+	}
+	if s.IngestMaxBytes <= 0 || s.IngestPublishQos > 2 {
+		return nil, fmt.Errorf("INGEST_MAX_BYTES must be positive and INGEST_PUBLISH_QOS at most 2")
+	}
+	err = s.loadCommands()
+	if err != nil {
+		return nil, err
+	}
+	if s.PublishTrustedProxies <= 0 {
+		return nil, fmt.Errorf("PUBLISH_TRUSTED_PROXIES must be positive")
+	}
+	if s.WsMaxSubscriptions <= 0 {
+		return nil, fmt.Errorf("WS_MAX_SUBSCRIPTIONS must be positive")
+	}
+	if s.CacheSnapshotInterval <= 0 {
+		return nil, fmt.Errorf("CACHE_SNAPSHOT_INTERVAL must be positive")
+	}
+	err = s.loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	s.mqttTLS, err = s.loadTLS()
+	if err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
-// ConnectMqtt connects to mqtt
+// ConnectMqtt connects to mqtt and waits for the initial subscription,
+// subscriptions are renewed on every reconnect
 func (s *Server) ConnectMqtt() (err error) {
+	servers := s.servers()
+	if s.MqttPersistentSession && s.MqttClientId == "go-mqtt-spacestatus-dev" {
+		log.Warn("persistent sessions need a unique MQTT_CLIENT_ID")
+	}
+	s.subscribed = make(chan error, 1)
+	s.setMqttState(StateConnecting)
+	s.registerMqttState()
 	m := mqtt.NewClient(&mqtt.ClientOptions{
-		Servers:       []*url.URL{s.MqttURL},
-		ClientID:      s.MqttClientId,
-		AutoReconnect: true,
+		Servers:              servers,
+		ClientID:             s.MqttClientId,
+		Username:             s.MqttUsername,
+		Password:             s.MqttPassword,
+		CleanSession:         !s.MqttPersistentSession,
+		TLSConfig:            s.mqttTLS,
+		ConnectTimeout:       s.MqttConnectTimeout,
+		AutoReconnect:        true,
+		MaxReconnectInterval: s.MqttMaxReconnect,
+		OnConnectAttempt: func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+			s.setMqttState(StateConnecting)
+			return s.brokers.onConnectAttempt(broker, tlsCfg)
+		},
 		OnConnect: func(c mqtt.Client) {
 			metrics.Count("spacestatus_mqtt{state=\"connected\"}")
-			log.Infof("connected")
+			s.setMqttState(StateConnected)
+			log.WithField("broker", s.brokers.onConnect()).Infof("connected")
+			select {
+			case s.republish <- struct{}{}:
+			default:
+			}
+			err := s.subscribe(c)
+			if err != nil {
+				metrics.Count("spacestatus_mqtt{state=\"subscribe_failed\"}")
+				log.WithError(err).Errorf("unable to subscribe")
+			}
+			// report the initial subscription to ConnectMqtt
+			select {
+			case s.subscribed <- err:
+			default:
+			}
 		},
 		OnConnectionLost: func(c mqtt.Client, err error) {
 			metrics.Count("spacestatus_mqtt{state=\"disconnected\"}")
+			s.setMqttState(StateLost)
 			log.WithError(err).Errorf("connection lost")
 		},
 	})
+	s.mqttClient = m
 	t := m.Connect()
 	_ = t.Wait()
 	if err := t.Error(); err != nil {
 		return err
 	}
-	t = m.Subscribe("#", 0, func(c mqtt.Client, m mqtt.Message) {
-		metrics.Count("spacestatus_mqtt{state=\"message\"}")
-		log.Debugf("%s: %s", m.Topic(), string(m.Payload()))
-		s.Cache.Store(m.Topic(), string(m.Payload()))
-	})
+	select {
+	case err = <-s.subscribed:
+		if err != nil {
+			return err
+		}
+	case <-time.After(s.MqttConnectTimeout):
+		return fmt.Errorf("no subscription acknowledged within %s", s.MqttConnectTimeout)
+	}
+	if s.StatusPublishTopic != "" || s.StatusPublishPrefix != "" {
+		go s.publishStatus()
+	}
+	return nil
+}
+
+// subscribe subscribes to the configured topic filters
+func (s *Server) subscribe(c mqtt.Client) error {
+	t := c.SubscribeMultiple(s.mqttTopics(), s.handleMessage)
 	t.Wait()
 	if err := t.Error(); err != nil {
 		return err
 	}
-	log.Println("subscribed")
-	return
+	for filter, code := range t.(*mqtt.SubscribeToken).Result() {
+		if code == 0x80 {
+			return fmt.Errorf("subscription to %q rejected by broker", filter)
+		}
+	}
+	s.setMqttState(StateSubscribed)
+	s.readiness.markSubscribed()
+	log.WithFields(log.Fields{
+		"topics":  s.topicFilters(),
+		"exclude": s.MqttExclude,
+	}).Info("subscribed")
+	return nil
+}
+
+// handleMessage stores messages matching the configured topic filters
+func (s *Server) handleMessage(c mqtt.Client, m mqtt.Message) {
+	if !s.wanted(m.Topic()) {
+		metrics.Count("spacestatus_mqtt{state=\"ignored\"}")
+		return
+	}
+	metrics.Count("spacestatus_mqtt{state=\"message\"}")
+	log.Debugf("%s: %s", m.Topic(), string(m.Payload()))
+	s.update(m.Topic(), m.Payload(), m.Retained(), m.Qos())
+}
+
+// update stores a topic value and notifies the history, storage and streams
+func (s *Server) update(t string, payload []byte, retained bool, qos byte) cache.Entry {
+	e := s.Cache.Set(t, payload, retained, qos)
+	s.record(e)
+	s.publish(e)
+	if s.referenced(e.Topic) {
+		s.invalidate()
+	}
+	return e
+}
+
+// record adds numeric values to the history and the tsdb
+func (s *Server) record(e cache.Entry) {
+	value, ok := history.Parse(e.Value)
+	if !ok {
+		return
+	}
+	if s.historyWanted(e.Topic) {
+		switch err := s.History.Add(e.Topic, e.ReceivedAt, value); err {
+		case history.ErrMaxTopics:
+			metrics.Count("spacestatus_history_dropped{reason=\"max_topics\"}")
+			log.WithField("topic", e.Topic).Debugf("history full, dropped sample")
+		case history.ErrInterval:
+			metrics.Count("spacestatus_history_dropped{reason=\"interval\"}")
+		}
+	}
+	if s.TSDB != nil && s.tsdbWanted(e.Topic) {
+		s.store(e.Topic, e.ReceivedAt, value)
+	}
+	switch e.Topic {
+	case s.StatsStatusTopic:
+		s.Stats.Status(e.ReceivedAt, value)
+	case s.StatsPeopleTopic:
+		s.Stats.People(e.ReceivedAt, value)
+	}
+}
+
+// historyWanted checks a topic against HISTORY_TOPICS, or the topics
+// referenced in the templates if it is not set
+func (s *Server) historyWanted(t string) bool {
+	if len(s.HistoryTopics) == 0 {
+		return s.referenced(t)
+	}
+	return topic.MatchAny(s.HistoryTopics, t)
+}
+
+// tsdbWanted checks a topic against TSDB_TOPICS, or the topics referenced
+// in the templates and the statistic topics if it is not set
+func (s *Server) tsdbWanted(t string) bool {
+	if len(s.TsdbTopics) == 0 {
+		return t == s.StatsStatusTopic || t == s.StatsPeopleTopic || s.referenced(t)
+	}
+	return topic.MatchAny(s.TsdbTopics, t)
+}
+
+// wanted checks a topic against the include and exclude filters
+func (s *Server) wanted(t string) bool {
+	if topic.MatchAny(s.MqttExclude, t) {
+		return false
+	}
+	for filter := range s.mqttTopics() {
+		if topic.Match(filter, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// mqttTopics returns the topic filters to subscribe to, the map must not
+// be modified
+func (s *Server) mqttTopics() map[string]byte {
+	s.topicsLock.RLock()
+	defer s.topicsLock.RUnlock()
+	return s.MqttTopics
+}
+
+// setMqttTopics replaces the topic filters to subscribe to
+func (s *Server) setMqttTopics(topics map[string]byte) {
+	s.topicsLock.Lock()
+	defer s.topicsLock.Unlock()
+	s.MqttTopics = topics
+}
+
+// topicFilters returns the subscribed topic filters with their QoS
+func (s *Server) topicFilters() []string {
+	subscriptions := s.mqttTopics()
+	filters := make([]string, 0, len(subscriptions))
+	for filter, qos := range subscriptions {
+		filters = append(filters, fmt.Sprintf("%s:%d", filter, qos))
+	}
+	sort.Strings(filters)
+	return filters
+}
+
+// templateFile is the status template, relative to the working directory
+const templateFile = "templates/status.json"
+
+// parseTemplates parses the template file with the template filters
+func (s *Server) parseTemplates() (*templateSet, error) {
+	info, err := os.Stat(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New("base").Funcs(template.FuncMap{
+		"mqtt":          filters.MqttLoad(s.lookup),
+		"mqttfresh":     filters.MqttFresh(s.lookup),
+		"mqttupdated":   filters.MqttUpdated(s.lookup),
+		"mqttchanged":   filters.MqttChanged(s.lookup),
+		"mqttage":       filters.MqttAge(s.lookup),
+		"mqttjson":      filters.MqttJSON(s.lookup),
+		"mqttmatch":     filters.MqttMatch(s.each),
+		"mqttmin":       filters.MqttAggregate(s.History, history.Min),
+		"mqttmax":       filters.MqttAggregate(s.History, history.Max),
+		"mqttavg":       filters.MqttAggregate(s.History, history.Avg),
+		"mqttrate":      filters.MqttAggregate(s.History, history.Rate),
+		"mqttlast":      filters.MqttLast(s.History),
+		"stateoverride": s.activeOverride,
+		"rfc3339":       filters.Rfc3339,
+		"csvlist":       filters.CsvList,
+		"jsonize":       filters.Jsonize,
+	}).ParseFiles(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	topics, dynamic := filters.TemplateTopics(t, filters.TopicFuncs)
+	log.WithFields(log.Fields{
+		"topics":  topics,
+		"dynamic": dynamic,
+	}).Info("discovered template topics")
+	return &templateSet{
+		template: t,
+		topics:   topics,
+		dynamic:  dynamic,
+		modTime:  info.ModTime(),
+		loadedAt: time.Now(),
+	}, nil
 }
 
 // LoadTemplates loads the template filters and files
 func (s *Server) LoadTemplates() (err error) {
-	s.template, err = template.New("base").Funcs(template.FuncMap{
-		"mqtt":    filters.MqttLoadForCache(s.Cache),
-		"csvlist": filters.CsvList,
-		"jsonize": filters.Jsonize,
-	}).ParseFiles("templates/status.json")
+	set, err := s.parseTemplates()
 	if err != nil {
 		return err
 	}
+	s.templates.Store(set)
+	if s.MqttTopicsFromTemplate {
+		s.setMqttTopics(s.templateSubscriptions(set.topics, set.dynamic))
+	}
 	return
 }
 
+// templateSubscriptions returns the topic filters to subscribe to instead
+// of the configured ones for the topics referenced in the templates and
+// the topics of the other features
+func (s *Server) templateSubscriptions(topics []string, dynamic int) map[string]byte {
+	subscriptions := map[string]byte{}
+	for _, t := range topics {
+		if err := topic.Valid(t); err != nil {
+			log.WithError(err).Warnf("skipping template topic")
+			continue
+		}
+		subscriptions[t] = 0
+	}
+	// topics used besides the templates
+	for _, filters := range [][]string{
+		s.ReadyTopics,
+		s.EventsTopics,
+		s.WsTopics,
+		{s.StateTopic, s.StatsStatusTopic, s.StatsPeopleTopic},
+	} {
+		for _, filter := range filters {
+			if filter == "" {
+				continue
+			}
+			if err := topic.Valid(filter); err != nil {
+				log.WithError(err).Warnf("skipping topic")
+				continue
+			}
+			subscriptions[filter] = 0
+		}
+	}
+	if dynamic == 0 {
+		return subscriptions
+	}
+	if s.MqttTemplateFallback == "" {
+		log.Warnf("%d dynamic template topics can not be subscribed, set MQTT_TEMPLATE_FALLBACK", dynamic)
+		return subscriptions
+	}
+	subscriptions[s.MqttTemplateFallback] = 0
+	return subscriptions
+}
+
+// TemplateTopics returns the topics referenced in the templates
+func (s *Server) TemplateTopics() []string {
+	return s.currentTemplates().topics
+}
+
 // Serve handles http
 func (s *Server) ListenAndServe() (err error) {
+	go s.countStale(10 * time.Second)
+	go s.watchDocument()
+	go s.watchTemplates()
+	s.invalidate()
 	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		metrics.Count("spacestatus_requests")
+		if s.notReady(w) {
+			metrics.Count("spacestatus_requests_not_ready")
+			return
+		}
 		w.Header().Add("content-type", "application/json")
-		err := s.template.ExecuteTemplate(w, "status.json", nil)
+		err := s.render(w)
 		if err != nil {
 			log.WithError(err).Infof("unable to render template")
 		}
 	})
 	s.mux.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir("static"))))
 	s.mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {})
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/api/v1/history", s.handleHistory)
+	s.mux.HandleFunc("/api/v1/events", s.handleEvents)
+	s.mux.HandleFunc("/api/v1/status/stream", s.handleDocumentStream)
+	s.mux.HandleFunc("/api/v1/ws", s.handleWebsocket)
+	s.mux.HandleFunc("/api/v1/state", s.handleState)
+	s.mux.HandleFunc("/api/v1/publish", s.handlePublish)
+	s.mux.HandleFunc("/api/v1/topics", s.handleTopics)
+	s.mux.HandleFunc("/api/v1/topics/", s.handleTopic)
+	s.mux.HandleFunc("/api/v1/template", s.handleTemplate)
+	s.mux.HandleFunc("/api/v1/stats/opening", s.handleOpeningStats)
+	s.mux.HandleFunc("/api/v1/stats/opening.svg", s.handleOpeningStats)
+	if s.Debug {
+		s.mux.HandleFunc("/debug/template-topics", func(w http.ResponseWriter, r *http.Request) {
+			if !s.topicsAuthorized(w, r) {
+				return
+			}
+			w.Header().Add("content-type", "application/json")
+			_ = json.NewEncoder(w).Encode(s.TemplateTopics())
+		})
+	}
 	middleware := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
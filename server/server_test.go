@@ -0,0 +1,98 @@
+package server
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// chdirRoot changes to the repository root for the templates
+func chdirRoot(t *testing.T) {
+	chdir(t, "..")
+}
+
+// chdir changes to dir until the test finishes
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+}
+
+// newTestServer creates a server configured with env
+func newTestServer(t *testing.T, env map[string]string) *Server {
+	for k, v := range env {
+		setenv(t, k, v)
+	}
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// setenv sets an environment variable until the test finishes
+func setenv(t *testing.T, k, v string) {
+	old, found := os.LookupEnv(k)
+	if err := os.Setenv(k, v); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if found {
+			_ = os.Setenv(k, old)
+		} else {
+			_ = os.Unsetenv(k)
+		}
+	})
+}
+
+// doneToken is a completed mqtt token
+type doneToken struct {
+	err error
+}
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Error() error                   { return t.err }
+func (t doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// published is a message sent with fakeClient
+type published struct {
+	Topic    string
+	QoS      byte
+	Retained bool
+	Payload  string
+}
+
+// fakeClient records published messages, other methods are not implemented
+type fakeClient struct {
+	mqtt.Client
+	lock      sync.Mutex
+	published []published
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.published = append(c.published, published{topic, qos, retained, string(payload.([]byte))})
+	return doneToken{}
+}
+
+func (c *fakeClient) messages() []published {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]published(nil), c.published...)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/metrics"
+)
+
+// LoadCache restores the cache snapshot and starts writing snapshots
+// periodically, nothing happens if no CACHE_FILE is configured
+func (s *Server) LoadCache() error {
+	if s.CacheFile == "" {
+		return nil
+	}
+	restored, err := s.Cache.Load(s.CacheFile)
+	switch {
+	case os.IsNotExist(err):
+		log.WithField("file", s.CacheFile).Info("no cache snapshot found")
+	case err != nil:
+		return err
+	default:
+		log.WithFields(log.Fields{
+			"file":     s.CacheFile,
+			"restored": restored,
+		}).Info("restored cache snapshot")
+	}
+	go s.snapshotCache(s.CacheSnapshotInterval)
+	return nil
+}
+
+// snapshotCache periodically saves the cache to CACHE_FILE
+func (s *Server) snapshotCache(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := s.Cache.Save(s.CacheFile)
+		if err != nil {
+			metrics.Count("spacestatus_cache_snapshots{state=\"failed\"}")
+			log.WithError(err).Errorf("unable to save cache snapshot")
+			continue
+		}
+		metrics.Count("spacestatus_cache_snapshots{state=\"success\"}")
+	}
+}
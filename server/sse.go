@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/b4ckspace/spacestatus/events"
+)
+
+// prepareSSE sets the headers of a server-sent event stream
+func prepareSSE(w http.ResponseWriter) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return nil, false
+	}
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("x-accel-buffering", "no")
+	return flusher, true
+}
+
+// streamSSE passes the events of sub to write and sends heartbeats until the
+// client disconnects or is dropped
+func (s *Server) streamSSE(w http.ResponseWriter, r *http.Request, flusher http.Flusher, sub *events.Subscription, write func(events.Event)) {
+	flusher.Flush()
+	heartbeat := time.NewTicker(s.EventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped as slow client
+				return
+			}
+			write(e)
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a server-sent event, the id is omitted if zero
+func writeEvent(w http.ResponseWriter, event string, id uint64, data []byte) {
+	if id != 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
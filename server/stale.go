@@ -0,0 +1,94 @@
+package server
+
+import (
+	"time"
+
+	"github.com/b4ckspace/spacestatus/cache"
+	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/topic"
+)
+
+// maxAge returns the configured maximum age for t. Exact topics take
+// precedence over patterns, the longest matching pattern wins otherwise.
+func (s *Server) maxAge(t string) (time.Duration, bool) {
+	if age, found := s.TopicMaxAge[t]; found {
+		return age, true
+	}
+	best := ""
+	for filter := range s.TopicMaxAge {
+		if !topic.Match(filter, t) {
+			continue
+		}
+		if len(filter) > len(best) || (len(filter) == len(best) && filter < best) {
+			best = filter
+		}
+	}
+	if best == "" {
+		return 0, false
+	}
+	return s.TopicMaxAge[best], true
+}
+
+// stale reports whether e is older than its configured maximum age
+func (s *Server) stale(e cache.Entry, now time.Time) bool {
+	age, found := s.maxAge(e.Topic)
+	return found && now.Sub(e.ReceivedAt) > age
+}
+
+// lookup returns the cached entry for t, stale entries are treated as missing.
+// An active state override takes precedence over the cache.
+func (s *Server) lookup(t string) (cache.Entry, bool) {
+	if e, found := s.overrideEntry(t); found {
+		return e, true
+	}
+	e, found := s.Cache.Get(t)
+	if !found {
+		return e, false
+	}
+	if s.stale(e, time.Now()) {
+		metrics.Count("spacestatus_mqtt_query{state=\"stale\"}")
+		return cache.Entry{}, false
+	}
+	return e, true
+}
+
+// countStale periodically exports the number of stale topics
+func (s *Server) countStale(interval time.Duration) {
+	last := 0
+	for range time.Tick(interval) {
+		count := s.staleTopics(time.Now())
+		metrics.Set("spacestatus_stale_topics", count)
+		if count != last {
+			// expired topics vanish from the rendered status
+			s.invalidate()
+			last = count
+		}
+	}
+}
+
+// staleTopics counts the cached topics older than their maximum age
+func (s *Server) staleTopics(now time.Time) int {
+	count := 0
+	s.Cache.Range(func(e cache.Entry) bool {
+		if s.stale(e, now) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// each calls f for all cache entries that are not stale, with the state
+// override applied
+func (s *Server) each(f func(cache.Entry) bool) {
+	now := time.Now()
+	s.Cache.Range(func(e cache.Entry) bool {
+		if o, found := s.overrideEntry(e.Topic); found {
+			return f(o)
+		}
+		if s.stale(e, now) {
+			return true
+		}
+		return f(e)
+	})
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/b4ckspace/spacestatus/cache"
+)
+
+func TestMaxAge(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"TOPIC_MAX_AGE": "sensor/#:1h,sensor/temperature/#:15m,sensor/temperature/+/shelf:5m,sensor/temperature/lab/shelf:1m,+/+/+/shelf:10m",
+	})
+	for _, tc := range []struct {
+		topic string
+		age   time.Duration
+		found bool
+	}{
+		{"sensor/temperature/lab/shelf", time.Minute, true},
+		{"sensor/temperature/hackcenter/shelf", 5 * time.Minute, true},
+		{"sensor/temperature/hackcenter/door", 15 * time.Minute, true},
+		{"sensor/power/main/total", time.Hour, true},
+		{"sensor", time.Hour, true},
+		{"bar/beer/cold/shelf", 10 * time.Minute, true},
+		{"door/front", 0, false},
+	} {
+		age, found := s.maxAge(tc.topic)
+		if age != tc.age || found != tc.found {
+			t.Errorf("maxAge(%q) = %s, %t, want %s, %t", tc.topic, age, found, tc.age, tc.found)
+		}
+	}
+}
+
+func TestMaxAgeTie(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"TOPIC_MAX_AGE": "sensor/+/b:1m,sensor/a/+:2m",
+	})
+	// patterns of the same length are ordered by name for a stable result
+	for i := 0; i < 10; i++ {
+		if age, _ := s.maxAge("sensor/a/b"); age != time.Minute {
+			t.Fatalf("maxAge = %s, want %s", age, time.Minute)
+		}
+	}
+}
+
+func TestStale(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"TOPIC_MAX_AGE": "sensor/#:1h,sensor/space/status:1ns",
+	})
+	s.Cache.Set("sensor/power/main/total", []byte("1234"), false, 0)
+	s.Cache.Set("sensor/space/status", []byte("open"), false, 0)
+	s.Cache.Set("door/front", []byte("closed"), false, 0)
+	time.Sleep(time.Millisecond)
+
+	now := time.Now()
+	for _, tc := range []struct {
+		now   time.Time
+		stale int
+	}{
+		{now, 1},
+		{now.Add(2 * time.Hour), 2},
+	} {
+		if stale := s.staleTopics(tc.now); stale != tc.stale {
+			t.Errorf("%s: %d stale topics, want %d", tc.now.Sub(now), stale, tc.stale)
+		}
+	}
+
+	for _, tc := range []struct {
+		topic string
+		found bool
+	}{
+		{"sensor/power/main/total", true},
+		{"sensor/space/status", false},
+		{"door/front", true},
+		{"door/back", false},
+	} {
+		if _, found := s.lookup(tc.topic); found != tc.found {
+			t.Errorf("lookup(%q) found %t, want %t", tc.topic, found, tc.found)
+		}
+	}
+
+	// the state override is never stale
+	s.setOverride(&stateOverride{State: "closed", SetAt: now.Add(-2 * time.Hour)})
+	if e, found := s.lookup("sensor/space/status"); !found || e.Value != "closed" {
+		t.Errorf("overridden lookup = %+v, %t", e, found)
+	}
+	topics := []string{}
+	s.each(func(e cache.Entry) bool {
+		topics = append(topics, e.Topic)
+		return true
+	})
+	sort.Strings(topics)
+	if diff := cmp.Diff([]string{"door/front", "sensor/power/main/total", "sensor/space/status"}, topics); diff != "" {
+		t.Errorf("invalid topics of each. \n%s", diff)
+	}
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/cache"
+	"github.com/b4ckspace/spacestatus/metrics"
+)
+
+// stateOverride forces the value of STATE_TOPIC
+type stateOverride struct {
+	State   string     `json:"state"`
+	Message string     `json:"message,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	SetAt   time.Time  `json:"set_at"`
+	SetBy   string     `json:"set_by"`
+}
+
+// overrides holds the active state override and its expiry timer
+type overrides struct {
+	lock    sync.Mutex
+	current *stateOverride
+	timer   *time.Timer
+}
+
+// stateRequest is the body of POST /api/v1/state
+type stateRequest struct {
+	State     string `json:"state"`
+	Message   string `json:"message"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// stateResponse is returned by the state api
+type stateResponse struct {
+	State    interface{}    `json:"state"`
+	Override *stateOverride `json:"override"`
+}
+
+// auditRecord is a line of the state audit log
+type auditRecord struct {
+	Time     time.Time      `json:"time"`
+	Action   string         `json:"action"`
+	By       string         `json:"by,omitempty"`
+	Remote   string         `json:"remote,omitempty"`
+	Override *stateOverride `json:"override,omitempty"`
+}
+
+// activeOverride returns the active override, nil if there is none
+func (s *Server) activeOverride() *stateOverride {
+	s.overrides.lock.Lock()
+	defer s.overrides.lock.Unlock()
+	return s.overrides.current
+}
+
+// overrideEntry returns the overridden cache entry for t
+func (s *Server) overrideEntry(t string) (cache.Entry, bool) {
+	if t != s.StateTopic {
+		return cache.Entry{}, false
+	}
+	o := s.activeOverride()
+	if o == nil {
+		return cache.Entry{}, false
+	}
+	return cache.Entry{
+		Topic:      t,
+		Payload:    []byte(o.State),
+		Value:      o.State,
+		ReceivedAt: o.SetAt,
+		ChangedAt:  o.SetAt,
+		FirstSeen:  o.SetAt,
+		Updates:    1,
+	}, true
+}
+
+// setOverride replaces the active override, nil clears it
+func (s *Server) setOverride(o *stateOverride) {
+	s.overrides.lock.Lock()
+	if s.overrides.timer != nil {
+		s.overrides.timer.Stop()
+		s.overrides.timer = nil
+	}
+	s.overrides.current = o
+	if o != nil && o.Expires != nil {
+		s.overrides.timer = time.AfterFunc(time.Until(*o.Expires), func() {
+			s.expireOverride(o)
+		})
+	}
+	s.overrides.lock.Unlock()
+	s.invalidate()
+	s.publishState()
+}
+
+// expireOverride clears o if it is still active
+func (s *Server) expireOverride(o *stateOverride) {
+	s.overrides.lock.Lock()
+	if s.overrides.current != o {
+		s.overrides.lock.Unlock()
+		return
+	}
+	s.overrides.current = nil
+	s.overrides.timer = nil
+	s.overrides.lock.Unlock()
+	s.invalidate()
+	s.publishState()
+	s.audit(auditRecord{Action: "expire", Override: o})
+}
+
+// publishState announces the value of STATE_TOPIC to the event subscribers
+// after the override changed, an unknown value is published as empty
+func (s *Server) publishState() {
+	e, found := s.lookup(s.StateTopic)
+	if !found {
+		e = cache.Entry{Topic: s.StateTopic}
+	}
+	s.publish(e)
+}
+
+// RestoreOverride restores the override that was active according to the
+// last record of STATE_AUDIT_FILE, nothing happens if it is not configured
+// or the override expired in the meantime
+func (s *Server) RestoreOverride() error {
+	if s.StateAuditFile == "" {
+		return nil
+	}
+	f, err := os.Open(s.StateAuditFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var last *stateOverride
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(lines.Bytes(), &record); err != nil {
+			// a torn line of a crash
+			log.WithError(err).WithField("file", s.StateAuditFile).Warn("skipping invalid audit record")
+			continue
+		}
+		last = nil
+		if record.Action == "set" {
+			last = record.Override
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+	if last == nil || last.Expires != nil && !last.Expires.After(time.Now()) {
+		return nil
+	}
+	s.setOverride(last)
+	log.WithFields(log.Fields{
+		"state":   last.State,
+		"expires": last.Expires,
+	}).Info("restored state override")
+	return nil
+}
+
+// audit logs a state change and appends it to STATE_AUDIT_FILE
+func (s *Server) audit(record auditRecord) {
+	record.Time = time.Now()
+	metrics.Count(fmt.Sprintf("spacestatus_state_override{action=%q}", record.Action))
+	fields := log.Fields{
+		"action": record.Action,
+		"by":     record.By,
+		"remote": record.Remote,
+	}
+	if record.Override != nil {
+		fields["state"] = record.Override.State
+		fields["message"] = record.Override.Message
+		fields["expires"] = record.Override.Expires
+	}
+	log.WithFields(fields).Info("state override")
+	if s.StateAuditFile == "" {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.WithError(err).Errorf("unable to encode audit record")
+		return
+	}
+	f, err := os.OpenFile(s.StateAuditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		log.WithError(err).Errorf("unable to open audit log")
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		log.WithError(err).Errorf("unable to write audit log")
+	}
+}
+
+// remoteHost returns the host of the request's remote address
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handleState shows, sets and clears the override of the open state
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	if len(s.stateTokens) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	metrics.Count("spacestatus_api_requests{endpoint=\"state\"}")
+	by, ok := requireToken(w, r, s.stateTokens)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req stateRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.State != "open" && req.State != "closed" {
+			http.Error(w, "invalid state, expected open or closed", http.StatusBadRequest)
+			return
+		}
+		o := &stateOverride{
+			State:   req.State,
+			Message: req.Message,
+			SetAt:   time.Now(),
+			SetBy:   by,
+		}
+		if req.ExpiresIn != "" {
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid expires_in, expected a positive duration", http.StatusBadRequest)
+				return
+			}
+			expires := o.SetAt.Add(d)
+			o.Expires = &expires
+		}
+		s.setOverride(o)
+		s.audit(auditRecord{Action: "set", By: by, Remote: remoteHost(r), Override: o})
+	case http.MethodDelete:
+		o := s.activeOverride()
+		if o != nil {
+			s.setOverride(nil)
+			s.audit(auditRecord{Action: "clear", By: by, Remote: remoteHost(r), Override: o})
+		}
+	default:
+		w.Header().Set("allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp := stateResponse{Override: s.activeOverride()}
+	if e, found := s.lookup(s.StateTopic); found {
+		resp.State = e.Value
+	}
+	w.Header().Add("content-type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.WithError(err).Infof("unable to encode state")
+	}
+}
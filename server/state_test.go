@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	s := newTestServer(t, map[string]string{
+		"STATE_TOKENS":     "alice:secret",
+		"STATE_AUDIT_FILE": auditFile,
+	})
+	s.Cache.Set(s.StateTopic, []byte("closed"), true, 0)
+	request := func(method, token, body string) (int, stateResponse) {
+		t.Helper()
+		r := httptest.NewRequest(method, "/api/v1/state", strings.NewReader(body))
+		r.RemoteAddr = "192.0.2.1:1234"
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.handleState(rec, r)
+		var resp stateResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, resp
+	}
+
+	for _, tc := range []struct {
+		name, token, body string
+		status            int
+	}{
+		{"missing token", "", `{"state": "open"}`, http.StatusUnauthorized},
+		{"invalid state", "secret", `{"state": "ajar"}`, http.StatusBadRequest},
+		{"invalid expiry", "secret", `{"state": "open", "expires_in": "-1h"}`, http.StatusBadRequest},
+	} {
+		if status, _ := request(http.MethodPost, tc.token, tc.body); status != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, status, tc.status)
+		}
+	}
+
+	status, resp := request(http.MethodPost, "secret", `{"state": "open", "message": "party"}`)
+	if status != http.StatusOK || resp.State != "open" || resp.Override == nil || resp.Override.SetBy != "alice" {
+		t.Errorf("set: status %d, %+v", status, resp)
+	}
+	if e, _ := s.lookup(s.StateTopic); e.Value != "open" {
+		t.Errorf("set: templates see %q, want open", e.Value)
+	}
+	status, resp = request(http.MethodDelete, "secret", "")
+	if status != http.StatusOK || resp.State != "closed" || resp.Override != nil {
+		t.Errorf("clear: status %d, %+v", status, resp)
+	}
+
+	status, resp = request(http.MethodPost, "secret", `{"state": "open", "expires_in": "50ms"}`)
+	if status != http.StatusOK || resp.Override == nil || resp.Override.Expires == nil {
+		t.Fatalf("set with expiry: status %d, %+v", status, resp)
+	}
+	// the expiry is audited after the override is cleared
+	var lines []string
+	for deadline := time.Now().Add(5 * time.Second); len(lines) < 4; {
+		if time.Now().After(deadline) {
+			t.Fatalf("override did not expire, audit log: \n%s", strings.Join(lines, "\n"))
+		}
+		time.Sleep(10 * time.Millisecond)
+		data, err := ioutil.ReadFile(auditFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	if _, resp = request(http.MethodGet, "secret", ""); resp.State != "closed" || resp.Override != nil {
+		t.Errorf("expired: %+v, want closed without override", resp)
+	}
+
+	want := []auditRecord{
+		{Action: "set", By: "alice", Remote: "192.0.2.1", Override: &stateOverride{State: "open", Message: "party"}},
+		{Action: "clear", By: "alice", Remote: "192.0.2.1", Override: &stateOverride{State: "open", Message: "party"}},
+		{Action: "set", By: "alice", Remote: "192.0.2.1", Override: &stateOverride{State: "open"}},
+		{Action: "expire", Override: &stateOverride{State: "open"}},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("%d audit lines, want %d: \n%s", len(lines), len(want), strings.Join(lines, "\n"))
+	}
+	for i, line := range lines {
+		var have auditRecord
+		if err := json.Unmarshal([]byte(line), &have); err != nil {
+			t.Fatal(err)
+		}
+		if have.Time.IsZero() || have.Override == nil {
+			t.Errorf("audit line %d: %s", i, line)
+			continue
+		}
+		if have.Action != want[i].Action || have.By != want[i].By || have.Remote != want[i].Remote ||
+			have.Override.State != want[i].Override.State || have.Override.Message != want[i].Override.Message {
+			t.Errorf("audit line %d: %s, want %+v", i, line, want[i])
+		}
+	}
+}
+
+func TestRestoreOverride(t *testing.T) {
+	now := time.Now()
+	record := func(action string, expires time.Duration) string {
+		r := auditRecord{Time: now, Action: action, By: "alice", Override: &stateOverride{State: "open", SetAt: now, SetBy: "alice"}}
+		if expires != 0 {
+			e := now.Add(expires)
+			r.Override.Expires = &e
+		}
+		line, _ := json.Marshal(r)
+		return string(line) + "\n"
+	}
+	for _, tc := range []struct {
+		name  string
+		audit string
+		want  bool
+	}{
+		{"no audit file", "", false},
+		{"set", record("set", 0), true},
+		{"cleared", record("set", 0) + record("clear", 0), false},
+		{"expired", record("set", -time.Minute) + record("expire", -time.Minute), false},
+		{"expired while stopped", record("set", -time.Minute), false},
+		{"set again", record("set", 0) + record("clear", 0) + record("set", time.Hour), true},
+		{"torn tail", record("set", time.Hour) + `{"time": "20`, true},
+	} {
+		auditFile := filepath.Join(t.TempDir(), "audit.log")
+		if tc.audit != "" {
+			if err := ioutil.WriteFile(auditFile, []byte(tc.audit), 0o640); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s := newTestServer(t, map[string]string{"STATE_AUDIT_FILE": auditFile})
+		if err := s.RestoreOverride(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		o := s.activeOverride()
+		if (o != nil) != tc.want {
+			t.Errorf("%s: override %+v, want restored %v", tc.name, o, tc.want)
+		}
+		if o != nil && (o.State != "open" || o.SetBy != "alice") {
+			t.Errorf("%s: invalid override %+v", tc.name, o)
+		}
+		s.setOverride(nil)
+	}
+}
+
+func TestOverrideEvents(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.Cache.Set(s.StateTopic, []byte("closed"), true, 0)
+	sub, _, _ := s.topicEvents.Subscribe(0)
+	defer s.topicEvents.Unsubscribe(sub)
+	next := func() topicEvent {
+		t.Helper()
+		select {
+		case e := <-sub.C:
+			var event topicEvent
+			if err := json.Unmarshal(e.Data, &event); err != nil {
+				t.Fatal(err)
+			}
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return topicEvent{}
+		}
+	}
+
+	expires := time.Now().Add(50 * time.Millisecond)
+	s.setOverride(&stateOverride{State: "open", SetAt: time.Now(), Expires: &expires})
+	if e := next(); e.Topic != s.StateTopic || e.Value != "open" {
+		t.Errorf("set: %+v", e)
+	}
+	if e := next(); e.Topic != s.StateTopic || e.Value != "closed" {
+		t.Errorf("expire: %+v", e)
+	}
+	s.setOverride(&stateOverride{State: "open", SetAt: time.Now()})
+	next()
+	s.setOverride(nil)
+	if e := next(); e.Topic != s.StateTopic || e.Value != "closed" {
+		t.Errorf("clear: %+v", e)
+	}
+}
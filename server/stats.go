@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/tsdb"
+)
+
+// seedStats restores the opening statistic from the tsdb. The raw tier
+// holds the transitions, beyond its retention only hourly averages are
+// left and the statistic is marked approximate.
+func (s *Server) seedStats() error {
+	now := time.Now()
+	from := now.Add(-s.StatsLookback)
+	// the first full hour within the raw retention
+	raw := now.Add(-s.TsdbRawRetention).Truncate(time.Hour).Add(time.Hour)
+	if raw.Before(from) {
+		raw = from
+	}
+	for t, add := range map[string]func(time.Time, float64){
+		s.StatsStatusTopic: s.Stats.Status,
+		s.StatsPeopleTopic: s.Stats.People,
+	} {
+		var points []tsdb.Point
+		if from.Before(raw) {
+			aggregated, err := s.TSDB.Query(t, from, raw)
+			if err != nil {
+				return err
+			}
+			for _, p := range aggregated {
+				if p.Start.Before(raw) {
+					points = append(points, p)
+				}
+			}
+		}
+		samples, err := s.TSDB.Query(t, raw, now)
+		if err != nil {
+			return err
+		}
+		for _, p := range append(points, samples...) {
+			add(p.Start, p.Avg())
+		}
+	}
+	if from.Before(raw) {
+		s.Stats.Approximate(raw)
+	}
+	return nil
+}
+
+// handleOpeningStats serves the opening heatmap as json or svg
+func (s *Server) handleOpeningStats(w http.ResponseWriter, r *http.Request) {
+	metrics.Count("spacestatus_api_requests{endpoint=\"stats_opening\"}")
+	h := s.Stats.Heatmap(time.Now(), s.statsLocation)
+	if strings.HasSuffix(r.URL.Path, ".svg") || r.URL.Query().Get("format") == "svg" {
+		w.Header().Add("content-type", "image/svg+xml")
+		err := h.SVG(w)
+		if err != nil {
+			log.WithError(err).Infof("unable to render heatmap")
+		}
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	err := json.NewEncoder(w).Encode(h)
+	if err != nil {
+		log.WithError(err).Infof("unable to encode heatmap")
+	}
+}
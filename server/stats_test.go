@@ -0,0 +1,63 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/b4ckspace/spacestatus/stats"
+	"github.com/b4ckspace/spacestatus/tsdb"
+)
+
+func TestSeedStats(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, map[string]string{"TSDB_DIR": dir, "STATS_TIMEZONE": "UTC"})
+	db, err := tsdb.Open(tsdb.Options{
+		Dir:             dir,
+		RawInterval:     s.TsdbRawInterval,
+		RawRetention:    s.TsdbRawRetention,
+		HourlyRetention: s.TsdbHourlyRetention,
+		DailyRetention:  s.TsdbDailyRetention,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now().UTC().Truncate(time.Hour)
+	old, recent := base.Add(-100*time.Hour), base.Add(-30*time.Hour)
+	for _, c := range []struct {
+		time  time.Time
+		value float64
+	}{
+		// beyond the raw retention only the hourly average of 0.5 is left
+		{old, 1},
+		{old.Add(30 * time.Minute), 0},
+		{recent.Add(10 * time.Minute), 1},
+		{recent.Add(40 * time.Minute), 0},
+	} {
+		if err := db.Add(s.StatsStatusTopic, c.time, c.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.OpenTSDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.TSDB.Close()
+	})
+	h := s.Stats.Heatmap(time.Now(), s.statsLocation)
+	slot := func(ts time.Time) stats.Slot {
+		return h.Slots[(int(ts.Weekday())+6)%7][ts.Hour()]
+	}
+	if have := slot(old); have.Open != 0.5 || have.Approximate != 1 {
+		t.Errorf("aggregated hour: %+v, want open 0.5 and 1 approximate hour", have)
+	}
+	// the raw transitions within the hour after 0.5 from the aggregate
+	want := (10*0.5 + 30*1) / 60
+	if have := slot(recent); math.Abs(have.Open-want) > 1e-9 || have.Approximate != 0 {
+		t.Errorf("raw hour: %+v, want open %v and no approximate hours", have, want)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/metrics"
+)
+
+// templateSet is a parsed template with the topics it references
+type templateSet struct {
+	template *template.Template
+	topics   []string
+	dynamic  int
+	modTime  time.Time
+	loadedAt time.Time
+}
+
+// templateReload tracks the last failed template reload
+type templateReload struct {
+	lock    sync.Mutex
+	err     error
+	errorAt time.Time
+}
+
+// templateStatus is returned by /api/v1/template
+type templateStatus struct {
+	LoadedAt    time.Time  `json:"loaded_at"`
+	ModifiedAt  time.Time  `json:"modified_at"`
+	Topics      []string   `json:"topics"`
+	Dynamic     int        `json:"dynamic"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// currentTemplates returns the active template set, an empty one if no
+// templates have been loaded yet
+func (s *Server) currentTemplates() *templateSet {
+	set, ok := s.templates.Load().(*templateSet)
+	if !ok {
+		return &templateSet{}
+	}
+	return set
+}
+
+// ReloadTemplates parses the templates again and activates them if they
+// render valid JSON, the previous templates stay active otherwise
+func (s *Server) ReloadTemplates() error {
+	err := s.reloadTemplates()
+	s.templateReload.lock.Lock()
+	defer s.templateReload.lock.Unlock()
+	if err != nil {
+		metrics.Count("spacestatus_template_reloads{result=\"failed\"}")
+		metrics.Set("spacestatus_template_reload_failed", 1)
+		log.WithError(err).Errorf("unable to reload templates, keeping the previous ones")
+		s.templateReload.err = err
+		s.templateReload.errorAt = time.Now()
+		return err
+	}
+	metrics.Count("spacestatus_template_reloads{result=\"success\"}")
+	metrics.Set("spacestatus_template_reload_failed", 0)
+	log.Info("reloaded templates")
+	s.templateReload.err = nil
+	return nil
+}
+
+func (s *Server) reloadTemplates() error {
+	set, err := s.parseTemplates()
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	err = set.template.ExecuteTemplate(buf, "status.json", nil)
+	if err != nil {
+		return fmt.Errorf("trial render failed: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return fmt.Errorf("trial render failed: invalid json")
+	}
+	s.templates.Store(set)
+	if s.MqttTopicsFromTemplate {
+		s.resubscribe(s.templateSubscriptions(set.topics, set.dynamic))
+	}
+	s.invalidate()
+	return nil
+}
+
+// resubscribe replaces the subscribed topic filters
+func (s *Server) resubscribe(topics map[string]byte) {
+	previous := s.mqttTopics()
+	s.setMqttTopics(topics)
+	if s.mqttClient == nil || !s.mqttClient.IsConnectionOpen() {
+		// subscribed on connect
+		return
+	}
+	added := map[string]byte{}
+	for filter, qos := range topics {
+		if q, found := previous[filter]; !found || q != qos {
+			added[filter] = qos
+		}
+	}
+	removed := []string{}
+	for filter := range previous {
+		if _, found := topics[filter]; !found {
+			removed = append(removed, filter)
+		}
+	}
+	if len(added) > 0 {
+		t := s.mqttClient.SubscribeMultiple(added, s.handleMessage)
+		t.Wait()
+		if err := t.Error(); err != nil {
+			log.WithError(err).Errorf("unable to subscribe to template topics")
+		}
+	}
+	if len(removed) > 0 {
+		t := s.mqttClient.Unsubscribe(removed...)
+		t.Wait()
+		if err := t.Error(); err != nil {
+			log.WithError(err).Errorf("unable to unsubscribe from template topics")
+		}
+	}
+	log.WithField("topics", s.topicFilters()).Info("subscribed")
+}
+
+// watchTemplates reloads the templates on SIGHUP and, with
+// TEMPLATE_POLL_INTERVAL, when the template file is modified
+func (s *Server) watchTemplates() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var poll <-chan time.Time
+	if s.TemplatePollInterval > 0 {
+		ticker := time.NewTicker(s.TemplatePollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	// attempted is the modification time of the last reload attempt, a
+	// broken template is not retried until it is modified again
+	attempted := s.currentTemplates().modTime
+	for {
+		select {
+		case <-hup:
+			log.Info("reloading templates on SIGHUP")
+		case <-poll:
+			info, err := os.Stat(templateFile)
+			if err != nil || info.ModTime().Equal(attempted) {
+				continue
+			}
+			log.Info("reloading modified templates")
+		}
+		if info, err := os.Stat(templateFile); err == nil {
+			attempted = info.ModTime()
+		}
+		_ = s.ReloadTemplates()
+	}
+}
+
+// handleTemplate shows the active templates and the last reload error
+func (s *Server) handleTemplate(w http.ResponseWriter, r *http.Request) {
+	metrics.Count("spacestatus_api_requests{endpoint=\"template\"}")
+	if !s.topicsAuthorized(w, r) {
+		return
+	}
+	set := s.currentTemplates()
+	status := templateStatus{
+		LoadedAt:   set.loadedAt,
+		ModifiedAt: set.modTime,
+		Topics:     set.topics,
+		Dynamic:    set.dynamic,
+	}
+	s.templateReload.lock.Lock()
+	if s.templateReload.err != nil {
+		status.LastError = s.templateReload.err.Error()
+		errorAt := s.templateReload.errorAt
+		status.LastErrorAt = &errorAt
+	}
+	s.templateReload.lock.Unlock()
+	w.Header().Add("content-type", "application/json")
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.WithError(err).Infof("unable to encode template status")
+	}
+}
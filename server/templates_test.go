@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReloadTemplates(t *testing.T) {
+	chdir(t, t.TempDir())
+	if err := os.Mkdir("templates", 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(text string) {
+		t.Helper()
+		if err := ioutil.WriteFile(templateFile, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	render := func(s *Server) string {
+		t.Helper()
+		buf := &bytes.Buffer{}
+		if err := s.render(buf); err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(buf.String())
+	}
+	status := func(s *Server) templateStatus {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.handleTemplate(rec, httptest.NewRequest(http.MethodGet, "/api/v1/template", nil))
+		var status templateStatus
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	write(`{"version": 1, "status": "{{mqtt "a"}}"}`)
+	s := newTestServer(t, nil)
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	s.Cache.Set("a", []byte("open"), false, 0)
+	s.Cache.Set("b", []byte("closed"), false, 0)
+	v1 := `{"version": 1, "status": "open"}`
+
+	for name, text := range map[string]string{
+		"syntax error": `{"version": 2, "status": "{{mqtt "b"}"}`,
+		"invalid json": `{"version": 2, "status": {{mqtt "b"}}}`,
+		"exec error":   `{"version": 2, "status": "{{mqtt}}"}`,
+	} {
+		write(text)
+		if err := s.ReloadTemplates(); err == nil {
+			t.Errorf("%s: reload succeeded", name)
+		}
+		if have := render(s); have != v1 {
+			t.Errorf("%s: rendered %s, want %s", name, have, v1)
+		}
+		if st := status(s); st.LastError == "" || st.LastErrorAt == nil || !cmp.Equal(st.Topics, []string{"a"}) {
+			t.Errorf("%s: template status %+v", name, st)
+		}
+	}
+
+	write(`{"version": 2, "status": "{{mqtt "b"}}"}`)
+	if err := s.ReloadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := render(s), `{"version": 2, "status": "closed"}`; have != want {
+		t.Errorf("reloaded: rendered %s, want %s", have, want)
+	}
+	if st := status(s); st.LastError != "" || !cmp.Equal(st.Topics, []string{"b"}) {
+		t.Errorf("reloaded: template status %+v", st)
+	}
+}
+
+func TestNoTemplates(t *testing.T) {
+	// ConnectMqtt may deliver messages before LoadTemplates
+	s := newTestServer(t, nil)
+	s.update("sensor/space/status", []byte("open"), true, 0)
+	if e, _ := s.Cache.Get("sensor/space/status"); e.Value != "open" {
+		t.Errorf("update not stored: %+v", e)
+	}
+	if s.referenced("sensor/space/status") {
+		t.Errorf("topic referenced without templates")
+	}
+	if err := s.render(&bytes.Buffer{}); err == nil {
+		t.Errorf("rendered without templates")
+	}
+	rec := httptest.NewRecorder()
+	s.handleTemplate(rec, httptest.NewRequest(http.MethodGet, "/api/v1/template", nil))
+	var status templateStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || rec.Code != http.StatusOK || len(status.Topics) != 0 {
+		t.Errorf("template status %d %+v, %v", rec.Code, status, err)
+	}
+}
+
+func TestTemplateSubscriptions(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"READY_TOPICS":           "sensor/door",
+		"WS_TOPICS":              "sensor/space/#",
+		"STATS_PEOPLE_TOPIC":     "",
+		"MQTT_TEMPLATE_FALLBACK": "sensor/#",
+	})
+	want := map[string]byte{
+		"sensor/power/main/total": 0,
+		"sensor/door":             0,
+		"sensor/space/#":          0,
+		"sensor/space/status":     0,
+	}
+	if diff := cmp.Diff(want, s.templateSubscriptions([]string{"sensor/power/main/total", "sensor/#/x"}, 0)); diff != "" {
+		t.Errorf("invalid subscriptions. \n%s", diff)
+	}
+	want["sensor/#"] = 0
+	if diff := cmp.Diff(want, s.templateSubscriptions([]string{"sensor/power/main/total"}, 1)); diff != "" {
+		t.Errorf("invalid subscriptions with dynamic topics. \n%s", diff)
+	}
+}
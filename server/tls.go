@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// loadCredentials resolves the mqtt username and password, reading them
+// from files if configured
+func (s *Server) loadCredentials() (err error) {
+	s.MqttUsername, err = readSecret("MQTT_USERNAME", s.MqttUsername, s.MqttUsernameFile)
+	if err != nil {
+		return err
+	}
+	s.MqttPassword, err = readSecret("MQTT_PASSWORD", s.MqttPassword, s.MqttPasswordFile)
+	if err != nil {
+		return err
+	}
+	if s.MqttPassword != "" && s.MqttUsername == "" {
+		return fmt.Errorf("MQTT_PASSWORD requires MQTT_USERNAME")
+	}
+	return nil
+}
+
+// readSecret returns value or the content of file without trailing newlines
+func readSecret(name, value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s and %s_FILE are mutually exclusive", name, name)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// loadTLS builds the tls config for mqtt, nil if TLS is not configured
+func (s *Server) loadTLS() (*tls.Config, error) {
+	if s.MqttCAFile == "" && s.MqttCertFile == "" && s.MqttKeyFile == "" && !s.MqttInsecureSkipVerify {
+		return nil, nil
+	}
+	for _, u := range s.MqttURLs {
+		if !tlsScheme(u.Scheme) {
+			return nil, fmt.Errorf("mqtt tls options set, but MQTT_URL %q does not use TLS", u.Redacted())
+		}
+	}
+	c := &tls.Config{
+		InsecureSkipVerify: s.MqttInsecureSkipVerify,
+	}
+	if s.MqttCAFile != "" {
+		pem, err := ioutil.ReadFile(s.MqttCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read MQTT_CA_FILE: %w", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in MQTT_CA_FILE %q", s.MqttCAFile)
+		}
+	}
+	if (s.MqttCertFile == "") != (s.MqttKeyFile == "") {
+		return nil, fmt.Errorf("MQTT_CERT_FILE and MQTT_KEY_FILE must be set together")
+	}
+	if s.MqttCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.MqttCertFile, s.MqttKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// tlsScheme reports whether paho connects to scheme using TLS
+func tlsScheme(scheme string) bool {
+	switch scheme {
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key as PEM
+func writeCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "spacestatus"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestLoadTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalid, []byte("no certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name                   string
+		url                    string
+		ca, cert, key          string
+		insecure               bool
+		err, tls, roots, certs bool
+	}{
+		{name: "plain", url: "tcp://mqtt:1883"},
+		{name: "tls without options", url: "ssl://mqtt:8883"},
+		{name: "ca", url: "ssl://mqtt:8883", ca: certFile, tls: true, roots: true},
+		{name: "client certificate", url: "mqtts://mqtt:8883", cert: certFile, key: keyFile, tls: true, certs: true},
+		{name: "insecure", url: "wss://mqtt/mqtt", insecure: true, tls: true},
+		{name: "options without tls", url: "tcp://mqtt:1883", ca: certFile, err: true},
+		{name: "missing ca", url: "ssl://mqtt:8883", ca: filepath.Join(dir, "missing.pem"), err: true},
+		{name: "invalid ca", url: "ssl://mqtt:8883", ca: invalid, err: true},
+		{name: "certificate without key", url: "ssl://mqtt:8883", cert: certFile, err: true},
+		{name: "invalid key", url: "ssl://mqtt:8883", cert: certFile, key: invalid, err: true},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Server{}
+		s.MqttURLs = []*url.URL{u}
+		s.MqttCAFile = tc.ca
+		s.MqttCertFile = tc.cert
+		s.MqttKeyFile = tc.key
+		s.MqttInsecureSkipVerify = tc.insecure
+		c, err := s.loadTLS()
+		if (err != nil) != tc.err {
+			t.Errorf("%s: error %v, want error %t", tc.name, err, tc.err)
+			continue
+		}
+		if (c != nil) != tc.tls {
+			t.Errorf("%s: tls config %+v, want tls %t", tc.name, c, tc.tls)
+			continue
+		}
+		if c == nil {
+			continue
+		}
+		if c.InsecureSkipVerify != tc.insecure || (c.RootCAs != nil) != tc.roots || (len(c.Certificates) > 0) != tc.certs {
+			t.Errorf("%s: insecure %t, roots %t, certificates %d", tc.name, c.InsecureSkipVerify, c.RootCAs != nil, len(c.Certificates))
+		}
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(file, []byte("secret\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := readSecret("MQTT_PASSWORD", "", file); err != nil || have != "secret" {
+		t.Errorf("from file: %q, %v", have, err)
+	}
+	if have, err := readSecret("MQTT_PASSWORD", "value", ""); err != nil || have != "value" {
+		t.Errorf("from value: %q, %v", have, err)
+	}
+	if _, err := readSecret("MQTT_PASSWORD", "value", file); err == nil {
+		t.Errorf("value and file accepted")
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/cache"
+	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/topic"
+)
+
+// loadIngestPermissions parses the ';' separated topic filters each
+// ingest token may write to
+func (s *Server) loadIngestPermissions() error {
+	s.ingestPermissions = map[string][]string{}
+	for name, filters := range s.IngestPermissions {
+		if _, found := s.ingestTokens[name]; !found {
+			return fmt.Errorf("INGEST_PERMISSIONS: unknown token %q", name)
+		}
+		for _, filter := range strings.Split(filters, ";") {
+			if err := topic.Valid(filter); err != nil {
+				return fmt.Errorf("INGEST_PERMISSIONS: %w", err)
+			}
+			s.ingestPermissions[name] = append(s.ingestPermissions[name], filter)
+		}
+	}
+	for name := range s.ingestTokens {
+		if _, found := s.ingestPermissions[name]; !found {
+			return fmt.Errorf("INGEST_PERMISSIONS: no topics for token %q", name)
+		}
+	}
+	return nil
+}
+
+// topicInfo is a cache entry as returned by the topics api
+type topicInfo struct {
+	cache.Entry
+	// Stale is set for entries older than their TOPIC_MAX_AGE
+	Stale bool `json:"stale"`
+	// Overridden is set if templates see the state override instead
+	Overridden bool `json:"overridden"`
+	// Redacted entries match TOPICS_API_REDACT and have their payload removed
+	Redacted bool `json:"redacted"`
+}
+
+// newTopicInfo describes e for the topics api
+func (s *Server) newTopicInfo(e cache.Entry, now time.Time) topicInfo {
+	_, overridden := s.overrideEntry(e.Topic)
+	info := topicInfo{
+		Entry:      e,
+		Stale:      s.stale(e, now),
+		Overridden: overridden,
+		Redacted:   topic.MatchAny(s.TopicsAPIRedact, e.Topic),
+	}
+	if info.Redacted {
+		info.Payload = nil
+		info.Value = ""
+	}
+	return info
+}
+
+// topicsAuthorized requires a token from TOPICS_API_TOKENS if any are set,
+// the topic and template apis are public otherwise
+func (s *Server) topicsAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.topicsTokens) == 0 {
+		return true
+	}
+	_, ok := requireToken(w, r, s.topicsTokens)
+	return ok
+}
+
+// handleTopics lists the cached topics matching the topic query parameter
+func (s *Server) handleTopics(w http.ResponseWriter, r *http.Request) {
+	metrics.Count("spacestatus_api_requests{endpoint=\"topics\"}")
+	if !s.topicsAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter := r.URL.Query().Get("topic")
+	if filter == "" {
+		filter = "#"
+	}
+	if err := topic.Valid(filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	topics := []topicInfo{}
+	s.Cache.Range(func(e cache.Entry) bool {
+		if topic.Match(filter, e.Topic) {
+			topics = append(topics, s.newTopicInfo(e, now))
+		}
+		return true
+	})
+	w.Header().Add("content-type", "application/json")
+	err := json.NewEncoder(w).Encode(topics)
+	if err != nil {
+		log.WithError(err).Infof("unable to encode topics")
+	}
+}
+
+// handleTopic serves /api/v1/topics/{topic}
+func (s *Server) handleTopic(w http.ResponseWriter, r *http.Request) {
+	t := strings.TrimPrefix(r.URL.Path, "/api/v1/topics/")
+	switch r.Method {
+	case http.MethodGet:
+		s.handleTopicGet(w, r, t)
+	case http.MethodPut:
+		s.handleIngest(w, r, t)
+	default:
+		w.Header().Set("allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTopicGet returns the cache entry of a topic
+func (s *Server) handleTopicGet(w http.ResponseWriter, r *http.Request, t string) {
+	metrics.Count("spacestatus_api_requests{endpoint=\"topic\"}")
+	if !s.topicsAuthorized(w, r) {
+		return
+	}
+	e, found := s.Cache.Get(t)
+	if !found {
+		http.Error(w, fmt.Sprintf("topic %q not cached", t), http.StatusNotFound)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	err := json.NewEncoder(w).Encode(s.newTopicInfo(e, time.Now()))
+	if err != nil {
+		log.WithError(err).Infof("unable to encode topic")
+	}
+}
+
+// handleIngest sets a topic value from the request body as if it was
+// received from MQTT. With INGEST_PUBLISH it is published to the broker
+// instead, and stored when it is received back if the topic is subscribed.
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request, t string) {
+	if len(s.ingestTokens) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	metrics.Count("spacestatus_api_requests{endpoint=\"ingest\"}")
+	name, ok := requireToken(w, r, s.ingestTokens)
+	if !ok {
+		return
+	}
+	if err := topic.Valid(t); err != nil || strings.ContainsAny(t, "+#") {
+		http.Error(w, fmt.Sprintf("invalid topic %q", t), http.StatusBadRequest)
+		return
+	}
+	if !topic.MatchAny(s.ingestPermissions[name], t) {
+		metrics.Count("spacestatus_ingest{result=\"forbidden\"}")
+		http.Error(w, fmt.Sprintf("token %q may not write to %q", name, t), http.StatusForbidden)
+		return
+	}
+	if topic.MatchAny(s.MqttExclude, t) {
+		metrics.Count("spacestatus_ingest{result=\"excluded\"}")
+		http.Error(w, fmt.Sprintf("topic %q is excluded by MQTT_EXCLUDE", t), http.StatusForbidden)
+		return
+	}
+	if r.ContentLength > s.IngestMaxBytes {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(r.Body, s.IngestMaxBytes+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to read payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if int64(len(payload)) > s.IngestMaxBytes {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type")); mediaType == "application/json" {
+		payload, err = jsonPayload(payload)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid json: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	retained, _ := strconv.ParseBool(r.URL.Query().Get("retain"))
+
+	var event topicEvent
+	status := http.StatusOK
+	if s.IngestPublish {
+		err = s.publishMessage(t, s.IngestPublishQos, retained, payload)
+		if err != nil {
+			metrics.Count("spacestatus_ingest{result=\"publish_failed\"}")
+			log.WithError(err).WithField("topic", t).Errorf("unable to publish ingested value")
+			http.Error(w, "unable to publish to mqtt", http.StatusBadGateway)
+			return
+		}
+	}
+	if s.IngestPublish && s.wanted(t) {
+		// stored when the broker delivers it back, storing it now as well
+		// would record the value twice
+		event = topicEvent{Topic: t, Value: string(payload), ReceivedAt: time.Now(), Retained: retained}
+		status = http.StatusAccepted
+		metrics.Count("spacestatus_ingest{result=\"published\"}")
+	} else {
+		event = newTopicEvent(s.update(t, payload, retained, 0))
+		metrics.Count("spacestatus_ingest{result=\"stored\"}")
+	}
+	log.WithFields(log.Fields{
+		"topic": t,
+		"token": name,
+	}).Debugf("ingested %s", string(payload))
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(event)
+	if err != nil {
+		log.WithError(err).Infof("unable to encode topic")
+	}
+}
+
+// jsonPayload validates a JSON payload. Strings are stored unquoted like
+// plain text, other values as compact JSON.
+func jsonPayload(data []byte) ([]byte, error) {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		return []byte(str), nil
+	}
+	compact := &bytes.Buffer{}
+	err := json.Compact(compact, data)
+	if err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
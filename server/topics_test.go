@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIngest(t *testing.T) {
+	chdirRoot(t)
+	s := newTestServer(t, map[string]string{
+		"INGEST_TOKENS":      "door:door-secret,bar:bar-secret",
+		"INGEST_PERMISSIONS": "door:sensor/door/#;sensor/space/status,bar:bar/#",
+		"INGEST_MAX_BYTES":   "16",
+		"MQTT_EXCLUDE":       "bar/secret/#",
+	})
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, topic, token, contentType, body string
+		chunked                               bool
+		status                                int
+		stored                                string
+	}{
+		{name: "missing token", topic: "bar/beer", body: "1", status: http.StatusUnauthorized},
+		{name: "invalid token", topic: "bar/beer", token: "door-secret!", body: "1", status: http.StatusUnauthorized},
+		{name: "other prefix", topic: "bar/beer", token: "door-secret", body: "1", status: http.StatusForbidden},
+		{name: "excluded", topic: "bar/secret/recipe", token: "bar-secret", body: "1", status: http.StatusForbidden},
+		{name: "wildcard", topic: "sensor/door/+", token: "door-secret", body: "1", status: http.StatusBadRequest},
+		{name: "too large", topic: "bar/beer", token: "bar-secret", body: strings.Repeat("1", 17), status: http.StatusRequestEntityTooLarge},
+		{name: "too large chunked", topic: "bar/beer", token: "bar-secret", body: strings.Repeat("1", 17), chunked: true, status: http.StatusRequestEntityTooLarge},
+		{name: "invalid json", topic: "bar/beer", token: "bar-secret", contentType: "application/json", body: "{", status: http.StatusBadRequest},
+		{name: "plain text", topic: "sensor/door/front", token: "door-secret", body: `"open"`, status: http.StatusOK, stored: `"open"`},
+		{name: "json string", topic: "sensor/space/status", token: "door-secret", contentType: "application/json; charset=utf-8", body: `"open"`, status: http.StatusOK, stored: "open"},
+		{name: "json object", topic: "bar/menu", token: "bar-secret", contentType: "application/json", body: `{ "beer": 2 }`, status: http.StatusOK, stored: `{"beer":2}`},
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/topics/"+tc.topic, strings.NewReader(tc.body))
+		if tc.chunked {
+			r.ContentLength = -1
+		}
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		if tc.contentType != "" {
+			r.Header.Set("content-type", tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		s.handleTopic(rec, r)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.status, rec.Body)
+			continue
+		}
+		e, found := s.Cache.Get(tc.topic)
+		if tc.stored == "" {
+			if found {
+				t.Errorf("%s: stored %q", tc.name, e.Value)
+			}
+			continue
+		}
+		if e.Value != tc.stored {
+			t.Errorf("%s: stored %q, want %q", tc.name, e.Value, tc.stored)
+		}
+		var event topicEvent
+		if err := json.NewDecoder(rec.Body).Decode(&event); err != nil || event.Value != tc.stored {
+			t.Errorf("%s: response %+v, %v", tc.name, event, err)
+		}
+	}
+}
+
+func TestIngestPublish(t *testing.T) {
+	chdirRoot(t)
+	s := newTestServer(t, map[string]string{
+		"INGEST_TOKENS":      "door:secret",
+		"INGEST_PERMISSIONS": "door:#",
+		"INGEST_PUBLISH":     "true",
+		"MQTT_TOPICS":        "sensor/#:0",
+		"MQTT_EXCLUDE":       "bar/secret/#",
+	})
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeClient{}
+	s.mqttClient = client
+	ingest := func(topic, body string) int {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/topics/"+topic+"?retain=true", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.handleTopic(rec, r)
+		return rec.Code
+	}
+
+	// subscribed topics are stored when the broker delivers them back
+	if status := ingest("sensor/space/status", "open"); status != http.StatusAccepted {
+		t.Errorf("subscribed topic: status %d, want %d", status, http.StatusAccepted)
+	}
+	if e, found := s.Cache.Get("sensor/space/status"); found {
+		t.Errorf("subscribed topic stored before the echo: %+v", e)
+	}
+	if status := ingest("bar/beer", "2"); status != http.StatusOK {
+		t.Errorf("unsubscribed topic: status %d, want %d", status, http.StatusOK)
+	}
+	if e, _ := s.Cache.Get("bar/beer"); e.Value != "2" || e.Updates != 1 {
+		t.Errorf("unsubscribed topic: %+v", e)
+	}
+	if status := ingest("bar/secret/recipe", "1"); status != http.StatusForbidden {
+		t.Errorf("excluded topic: status %d, want %d", status, http.StatusForbidden)
+	}
+	want := []published{
+		{Topic: "sensor/space/status", QoS: 1, Retained: true, Payload: "open"},
+		{Topic: "bar/beer", QoS: 1, Retained: true, Payload: "2"},
+	}
+	if diff := cmp.Diff(want, client.messages()); diff != "" {
+		t.Errorf("invalid published messages. \n%s", diff)
+	}
+}
+
+func TestTopicsAPI(t *testing.T) {
+	chdirRoot(t)
+	s := newTestServer(t, map[string]string{
+		"TOPICS_API_TOKENS": "display:secret",
+		"TOPICS_API_REDACT": "sensor/space/member/names",
+		"TOPIC_MAX_AGE":     "sensor/space/member/count:1ns",
+	})
+	if err := s.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	s.Cache.Set("sensor/space/status", []byte("closed"), true, 0)
+	s.Cache.Set("sensor/space/member/names", []byte("a, b"), true, 0)
+	s.Cache.Set("sensor/space/member/count", []byte("2"), true, 0)
+	s.Cache.Set("sensor/power/main/total", []byte("1234"), true, 0)
+	s.setOverride(&stateOverride{State: "open", SetAt: time.Now()})
+	time.Sleep(time.Millisecond)
+	request := func(handler http.HandlerFunc, target, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		token   string
+		status  int
+	}{
+		{"list without token", s.handleTopics, "/api/v1/topics", "", http.StatusUnauthorized},
+		{"list with invalid token", s.handleTopics, "/api/v1/topics", "wrong", http.StatusUnauthorized},
+		{"invalid filter", s.handleTopics, "/api/v1/topics?topic=sensor/%23/space", "secret", http.StatusBadRequest},
+		{"topic without token", s.handleTopic, "/api/v1/topics/sensor/space/status", "", http.StatusUnauthorized},
+		{"unknown topic", s.handleTopic, "/api/v1/topics/sensor/space/door", "secret", http.StatusNotFound},
+		{"template without token", s.handleTemplate, "/api/v1/template", "", http.StatusUnauthorized},
+		{"template", s.handleTemplate, "/api/v1/template", "secret", http.StatusOK},
+	} {
+		if rec := request(tc.handler, tc.target, tc.token); rec.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.status)
+		}
+	}
+
+	rec := request(s.handleTopics, "/api/v1/topics?topic=sensor/space/%23", "secret")
+	var topics []topicInfo
+	if err := json.NewDecoder(rec.Body).Decode(&topics); err != nil {
+		t.Fatal(err)
+	}
+	type flags struct {
+		Value                       string
+		Stale, Overridden, Redacted bool
+	}
+	have := map[string]flags{}
+	for _, info := range topics {
+		have[info.Topic] = flags{info.Value, info.Stale, info.Overridden, info.Redacted}
+		if info.Redacted && info.Payload != nil {
+			t.Errorf("%s: redacted payload %q", info.Topic, info.Payload)
+		}
+	}
+	want := map[string]flags{
+		"sensor/space/status":       {Value: "closed", Overridden: true},
+		"sensor/space/member/names": {Redacted: true},
+		"sensor/space/member/count": {Value: "2", Stale: true},
+	}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("invalid topics. \n%s", diff)
+	}
+
+	rec = request(s.handleTopic, "/api/v1/topics/sensor/space/member/names", "secret")
+	var info topicInfo
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Topic != "sensor/space/member/names" || !info.Redacted || info.Value != "" || info.Payload != nil {
+		t.Errorf("topic not redacted: %+v", info)
+	}
+}
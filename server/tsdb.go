@@ -0,0 +1,110 @@
+package server
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/history"
+	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/tsdb"
+)
+
+// sample is a value queued for the tsdb
+type sample struct {
+	topic string
+	time  time.Time
+	value float64
+}
+
+// OpenTSDB opens the on-disk time series storage and starts writing
+// samples to it, nothing happens if no TSDB_DIR is configured
+func (s *Server) OpenTSDB() (err error) {
+	if s.TsdbDir == "" {
+		return nil
+	}
+	s.TSDB, err = tsdb.Open(tsdb.Options{
+		Dir:             s.TsdbDir,
+		RawInterval:     s.TsdbRawInterval,
+		RawRetention:    s.TsdbRawRetention,
+		HourlyRetention: s.TsdbHourlyRetention,
+		DailyRetention:  s.TsdbDailyRetention,
+	})
+	if err != nil {
+		return err
+	}
+	err = s.seedStats()
+	if err != nil {
+		return err
+	}
+	s.samples = make(chan sample, 1024)
+	go s.writeSamples()
+	go s.maintainTSDB()
+	log.WithField("dir", s.TsdbDir).Info("opened tsdb")
+	return nil
+}
+
+// store queues a sample for the tsdb without blocking the mqtt callback
+func (s *Server) store(topic string, t time.Time, value float64) {
+	select {
+	case s.samples <- sample{topic: topic, time: t, value: value}:
+	default:
+		metrics.Count("spacestatus_tsdb_samples{state=\"dropped\"}")
+	}
+}
+
+func (s *Server) writeSamples() {
+	for sample := range s.samples {
+		err := s.TSDB.Add(sample.topic, sample.time, sample.value)
+		if err != nil {
+			metrics.Count("spacestatus_tsdb_samples{state=\"failed\"}")
+			log.WithError(err).Errorf("unable to store sample")
+			continue
+		}
+		metrics.Count("spacestatus_tsdb_samples{state=\"stored\"}")
+	}
+}
+
+// maintainTSDB periodically syncs and compacts the tsdb
+func (s *Server) maintainTSDB() {
+	sync := time.NewTicker(10 * time.Second)
+	compact := time.NewTicker(s.TsdbCompactInterval)
+	for {
+		select {
+		case <-sync.C:
+			err := s.TSDB.Sync()
+			if err != nil {
+				log.WithError(err).Errorf("unable to sync tsdb")
+			}
+		case <-compact.C:
+			err := s.TSDB.Compact()
+			if err != nil {
+				metrics.Count("spacestatus_tsdb_compactions{state=\"failed\"}")
+				log.WithError(err).Errorf("unable to compact tsdb")
+				continue
+			}
+			metrics.Count("spacestatus_tsdb_compactions{state=\"success\"}")
+		}
+	}
+}
+
+// storedSamples returns the points stored in the tsdb as samples, using
+// the min, max or avg of aggregated points
+func (s *Server) storedSamples(topic string, from, to time.Time, agg string) ([]history.Sample, error) {
+	points, err := s.TSDB.Query(topic, from, to)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]history.Sample, 0, len(points))
+	for _, p := range points {
+		value := p.Avg()
+		switch agg {
+		case "min":
+			value = p.Min
+		case "max":
+			value = p.Max
+		}
+		samples = append(samples, history.Sample{Time: p.Start, Value: value})
+	}
+	return samples, nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/b4ckspace/spacestatus/cache"
+	"github.com/b4ckspace/spacestatus/metrics"
+	"github.com/b4ckspace/spacestatus/topic"
+)
+
+// wsRequest is a message sent by websocket clients
+type wsRequest struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+}
+
+// wsFrame is a message sent to websocket clients
+type wsFrame struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// checkOrigin allows browsers from WS_ORIGINS, or from the same host if
+// unset. Clients without an Origin header are not browsers and allowed.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(s.WsOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range s.WsOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsAllowed checks whether all topics matched by filter are in WS_TOPICS
+func (s *Server) wsAllowed(filter string) error {
+	if err := topic.Valid(filter); err != nil {
+		return err
+	}
+	for _, allowed := range s.WsTopics {
+		if topic.Covers(allowed, filter) {
+			return nil
+		}
+	}
+	return fmt.Errorf("topic filter %q is not allowed", filter)
+}
+
+// keepAlive extends the read deadline of conn, peers that neither send
+// messages nor answer the heartbeat pings within two heartbeats are closed
+func (s *Server) keepAlive(conn *websocket.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(2 * s.EventsHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * s.EventsHeartbeat))
+	})
+}
+
+// handleWebsocket bridges topic changes to browsers. Clients subscribe to
+// topic filters within WS_TOPICS and receive the cached values as snapshot
+// frames followed by update frames, messages can not be published.
+func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	if len(s.WsTopics) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	metrics.Count("spacestatus_api_requests{endpoint=\"ws\"}")
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			if !s.checkOrigin(r) {
+				metrics.Count("spacestatus_ws_rejected{reason=\"origin\"}")
+				return false
+			}
+			return true
+		},
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithError(err).Infof("unable to upgrade websocket")
+		return
+	}
+	defer conn.Close()
+	metrics.Set("spacestatus_ws_connections", int(atomic.AddInt32(&s.wsConnections, 1)))
+	defer func() {
+		metrics.Set("spacestatus_ws_connections", int(atomic.AddInt32(&s.wsConnections, -1)))
+	}()
+
+	sub, _, _ := s.topicEvents.Subscribe(0)
+	defer s.topicEvents.Unsubscribe(sub)
+
+	requests := make(chan wsRequest)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	conn.SetReadLimit(4096)
+	s.keepAlive(conn)
+	go func() {
+		defer close(closed)
+		for {
+			var req wsRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			s.keepAlive(conn)
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// subscriptions maps the topic filters to the last event id included
+	// in their snapshot
+	subscriptions := map[string]uint64{}
+	heartbeat := time.NewTicker(s.EventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		case req := <-requests:
+			err = s.handleWebsocketRequest(conn, subscriptions, req)
+		case e, ok := <-sub.C:
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			for filter, since := range subscriptions {
+				if e.ID > since && topic.Match(filter, e.Topic) {
+					err = conn.WriteJSON(wsFrame{Type: "update", Topic: e.Topic, Data: s.redactEvent(e.Topic, e.Data)})
+					break
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleWebsocketRequest updates the subscriptions of a websocket client
+func (s *Server) handleWebsocketRequest(conn *websocket.Conn, subscriptions map[string]uint64, req wsRequest) error {
+	reject := func(err error) error {
+		metrics.Count("spacestatus_ws_rejected{reason=\"request\"}")
+		return conn.WriteJSON(wsFrame{Type: "error", Topic: req.Topic, Error: err.Error()})
+	}
+	switch req.Type {
+	case "subscribe":
+		if err := s.wsAllowed(req.Topic); err != nil {
+			return reject(err)
+		}
+		if _, found := subscriptions[req.Topic]; !found && len(subscriptions) >= s.WsMaxSubscriptions {
+			return reject(fmt.Errorf("too many subscriptions, at most %d are allowed", s.WsMaxSubscriptions))
+		}
+		// newer events are sent as updates, older ones are part of the snapshot
+		since := s.topicEvents.LastID()
+		var err error
+		s.each(func(e cache.Entry) bool {
+			if !topic.Match(req.Topic, e.Topic) {
+				return true
+			}
+			data, _ := json.Marshal(newTopicEvent(e))
+			err = conn.WriteJSON(wsFrame{Type: "snapshot", Topic: e.Topic, Data: s.redactEvent(e.Topic, data)})
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		subscriptions[req.Topic] = since
+		return conn.WriteJSON(wsFrame{Type: "subscribed", Topic: req.Topic})
+	case "unsubscribe":
+		delete(subscriptions, req.Topic)
+		return conn.WriteJSON(wsFrame{Type: "unsubscribed", Topic: req.Topic})
+	default:
+		return reject(fmt.Errorf("unknown request type %q, expected subscribe or unsubscribe", req.Type))
+	}
+}
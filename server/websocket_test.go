@@ -0,0 +1,157 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		origins string
+		origin  string
+		allowed bool
+	}{
+		{"no browser", "", "", true},
+		{"same host", "", "http://status.example.org", true},
+		{"other host", "", "https://evil.example.org", false},
+		{"invalid origin", "", "://", false},
+		{"configured", "https://status.example.org/", "https://Status.example.org", true},
+		{"not configured", "https://status.example.org", "http://status.example.org", false},
+		{"wildcard", "*", "https://evil.example.org", true},
+	} {
+		s := newTestServer(t, map[string]string{"WS_ORIGINS": tc.origins})
+		r := httptest.NewRequest(http.MethodGet, "http://status.example.org/api/v1/ws", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if allowed := s.checkOrigin(r); allowed != tc.allowed {
+			t.Errorf("%s: allowed %v, want %v", tc.name, allowed, tc.allowed)
+		}
+	}
+}
+
+func TestWsAllowed(t *testing.T) {
+	s := newTestServer(t, map[string]string{"WS_TOPICS": "sensor/space/#,sensor/power/main/total"})
+	for filter, allowed := range map[string]bool{
+		"sensor/space/#":          true,
+		"sensor/space/status":     true,
+		"sensor/space/+/count":    true,
+		"sensor/power/main/total": true,
+		"sensor/power/+/total":    false,
+		"sensor/#":                false,
+		"#":                       false,
+		"sensor/#/space":          false,
+	} {
+		if err := s.wsAllowed(filter); (err == nil) != allowed {
+			t.Errorf("%s: got %v, want allowed %v", filter, err, allowed)
+		}
+	}
+}
+
+// dialWebsocket connects to the topic websocket of s
+func dialWebsocket(t *testing.T, s *Server) *websocket.Conn {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebsocket))
+	t.Cleanup(ts.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func TestWebsocket(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"WS_TOPICS":            "sensor/space/#",
+		"WS_MAX_SUBSCRIPTIONS": "2",
+		"TOPICS_API_REDACT":    "sensor/space/member/names",
+	})
+	s.Cache.Set("sensor/space/status", []byte("open"), true, 0)
+	s.Cache.Set("sensor/space/member/names", []byte("a, b"), true, 0)
+	conn := dialWebsocket(t, s)
+	// next reads the next frame and checks its type and topic
+	next := func(typ, topic string) wsFrame {
+		t.Helper()
+		var frame wsFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.Type != typ || frame.Topic != topic {
+			t.Fatalf("got %s %s frame %+v, want %s %s", frame.Type, frame.Topic, frame, typ, topic)
+		}
+		return frame
+	}
+	subscribe := func(filter string) {
+		t.Helper()
+		if err := conn.WriteJSON(wsRequest{Type: "subscribe", Topic: filter}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subscribe("sensor/space/status")
+	if frame := next("snapshot", "sensor/space/status"); !strings.Contains(string(frame.Data), `"value":"open"`) {
+		t.Errorf("invalid snapshot %s", frame.Data)
+	}
+	next("subscribed", "sensor/space/status")
+	s.update("sensor/space/status", []byte("closed"), false, 0)
+	if frame := next("update", "sensor/space/status"); !strings.Contains(string(frame.Data), `"value":"closed"`) {
+		t.Errorf("invalid update %s", frame.Data)
+	}
+
+	subscribe("sensor/space/member/#")
+	frame := next("snapshot", "sensor/space/member/names")
+	if strings.Contains(string(frame.Data), "a, b") || !strings.Contains(string(frame.Data), `"redacted":true`) {
+		t.Errorf("snapshot not redacted: %s", frame.Data)
+	}
+	next("subscribed", "sensor/space/member/#")
+	s.update("sensor/space/member/names", []byte("c"), false, 0)
+	frame = next("update", "sensor/space/member/names")
+	if strings.Contains(string(frame.Data), `"value":"c"`) || !strings.Contains(string(frame.Data), `"redacted":true`) {
+		t.Errorf("update not redacted: %s", frame.Data)
+	}
+
+	subscribe("sensor/space/member/count")
+	if frame := next("error", "sensor/space/member/count"); !strings.Contains(frame.Error, "at most 2") {
+		t.Errorf("invalid error %q", frame.Error)
+	}
+	subscribe("sensor/#")
+	next("error", "sensor/#")
+	// resubscribing does not count against the limit
+	subscribe("sensor/space/status")
+	next("snapshot", "sensor/space/status")
+	next("subscribed", "sensor/space/status")
+	if err := conn.WriteJSON(wsRequest{Type: "unsubscribe", Topic: "sensor/space/status"}); err != nil {
+		t.Fatal(err)
+	}
+	next("unsubscribed", "sensor/space/status")
+	subscribe("sensor/space/member/count")
+	next("subscribed", "sensor/space/member/count")
+}
+
+func TestWebsocketPingTimeout(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"WS_TOPICS":        "sensor/space/#",
+		"EVENTS_HEARTBEAT": "20ms",
+	})
+	// the client never reads, so the pings are not answered
+	dialWebsocket(t, s)
+	for _, want := range []int32{1, 0} {
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&s.wsConnections) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d connections, want %d", atomic.LoadInt32(&s.wsConnections), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
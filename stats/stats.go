@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// Change is a value a series took at a point in time
+type Change struct {
+	Time  time.Time
+	Value float64
+}
+
+// Tracker records the changes of the space status and the number of
+// people present within a lookback window, safe for concurrent use
+type Tracker struct {
+	lock     sync.Mutex
+	lookback time.Duration
+	status   []Change
+	people   []Change
+	// changes before approximate were seeded from aggregates
+	approximate time.Time
+}
+
+func NewTracker(lookback time.Duration) *Tracker {
+	return &Tracker{
+		lookback: lookback,
+	}
+}
+
+// Status records the space status, 1 for open and 0 for closed
+func (t *Tracker) Status(ts time.Time, open float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status = t.add(t.status, Change{Time: ts, Value: open})
+}
+
+// People records the number of people present
+func (t *Tracker) People(ts time.Time, count float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.people = t.add(t.people, Change{Time: ts, Value: count})
+}
+
+// Approximate marks the changes before until as approximate, e.g. when
+// they were restored from hourly averages instead of transitions
+func (t *Tracker) Approximate(until time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.approximate = until
+}
+
+// add appends c if it changes the value and drops changes beyond the
+// lookback, keeping the last one as the state at the start of the window
+func (t *Tracker) add(changes []Change, c Change) []Change {
+	if n := len(changes); n > 0 {
+		if changes[n-1].Value == c.Value || c.Time.Before(changes[n-1].Time) {
+			return changes
+		}
+	}
+	changes = append(changes, c)
+	oldest := c.Time.Add(-t.lookback)
+	drop := 0
+	for drop+1 < len(changes) && !changes[drop+1].Time.After(oldest) {
+		drop++
+	}
+	return changes[drop:]
+}
+
+// Slot is the statistic of one hour of a weekday
+type Slot struct {
+	Weekday string `json:"weekday"`
+	Hour    int    `json:"hour"`
+	// Open is the fraction of the observed time the space was open
+	Open float64 `json:"open_probability"`
+	// People is the average number of people present
+	People   float64 `json:"average_people"`
+	Observed float64 `json:"observed_hours"`
+	// Approximate is the part of the observed hours restored from aggregates
+	Approximate float64 `json:"approximate_hours"`
+}
+
+// Heatmap is the opening statistic per weekday and hour, starting on Monday
+type Heatmap struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Slots [][]Slot  `json:"slots"`
+}
+
+// Heatmap computes the time weighted opening probability and attendance
+// per weekday and hour in loc over the lookback until now
+func (t *Tracker) Heatmap(now time.Time, loc *time.Location) Heatmap {
+	t.lock.Lock()
+	status := append([]Change(nil), t.status...)
+	people := append([]Change(nil), t.people...)
+	approximate := t.approximate
+	t.lock.Unlock()
+
+	h := Heatmap{
+		From:  now.Add(-t.lookback),
+		To:    now,
+		Slots: make([][]Slot, 7),
+	}
+	open, openObserved := weigh(status, h.From, now, loc)
+	count, countObserved := weigh(people, h.From, now, loc)
+	var approximated [7][24]float64
+	if approximate.After(h.From) {
+		if approximate.After(now) {
+			approximate = now
+		}
+		_, approximated = weigh(status, h.From, approximate, loc)
+	}
+	for day := range h.Slots {
+		h.Slots[day] = make([]Slot, 24)
+		for hour := range h.Slots[day] {
+			slot := Slot{
+				Weekday:     time.Weekday((day + 1) % 7).String(),
+				Hour:        hour,
+				Observed:    openObserved[day][hour] / 3600,
+				Approximate: approximated[day][hour] / 3600,
+			}
+			if openObserved[day][hour] > 0 {
+				slot.Open = open[day][hour] / openObserved[day][hour]
+			}
+			if countObserved[day][hour] > 0 {
+				slot.People = count[day][hour] / countObserved[day][hour]
+			}
+			h.Slots[day][hour] = slot
+		}
+	}
+	return h
+}
+
+// weigh sums value * seconds and the observed seconds per weekday and
+// hour, each change lasts until the next one or until to
+func weigh(changes []Change, from, to time.Time, loc *time.Location) (sum, observed [7][24]float64) {
+	for i, c := range changes {
+		start, end := c.Time, to
+		if i+1 < len(changes) && changes[i+1].Time.Before(to) {
+			end = changes[i+1].Time
+		}
+		if start.Before(from) {
+			start = from
+		}
+		for start.Before(end) {
+			local := start.In(loc)
+			// the local hour ends an hour after it started, time.Date is
+			// ambiguous for the hour repeated at the end of daylight saving time
+			next := start.Add(time.Hour - time.Duration(local.Minute())*time.Minute -
+				time.Duration(local.Second())*time.Second - time.Duration(local.Nanosecond()))
+			if next.After(end) {
+				next = end
+			}
+			seconds := next.Sub(start).Seconds()
+			day := (int(local.Weekday()) + 6) % 7
+			sum[day][local.Hour()] += c.Value * seconds
+			observed[day][local.Hour()] += seconds
+			start = next
+		}
+	}
+	return sum, observed
+}
@@ -0,0 +1,159 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func berlin(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	return loc
+}
+
+func TestWeigh(t *testing.T) {
+	loc := berlin(t)
+	// Monday
+	from := time.Date(2024, 6, 3, 10, 0, 0, 0, loc)
+	changes := []Change{
+		{Time: from.Add(-time.Hour), Value: 1},
+		{Time: from.Add(30 * time.Minute), Value: 0},
+		{Time: from.Add(90 * time.Minute), Value: 4},
+	}
+	sum, observed := weigh(changes, from, from.Add(2*time.Hour), loc)
+	for _, tc := range []struct {
+		day, hour     int
+		sum, observed float64
+	}{
+		{0, 9, 0, 0},
+		{0, 10, 1800, 3600},
+		{0, 11, 4 * 1800, 3600},
+		{0, 12, 0, 0},
+	} {
+		if sum[tc.day][tc.hour] != tc.sum || observed[tc.day][tc.hour] != tc.observed {
+			t.Errorf("%d %02d:00: sum %v observed %v, want %v %v", tc.day, tc.hour,
+				sum[tc.day][tc.hour], observed[tc.day][tc.hour], tc.sum, tc.observed)
+		}
+	}
+}
+
+func TestWeighDST(t *testing.T) {
+	loc := berlin(t)
+	for _, tc := range []struct {
+		name string
+		// local midnight of a Sunday with a transition
+		day time.Time
+		// observed hours of the day and of the hour 02:00
+		hours, two float64
+	}{
+		{"spring forward", time.Date(2024, 3, 31, 0, 0, 0, 0, loc), 23, 0},
+		{"fall back", time.Date(2024, 10, 27, 0, 0, 0, 0, loc), 25, 2},
+	} {
+		end := time.Date(tc.day.Year(), tc.day.Month(), tc.day.Day()+1, 0, 0, 0, 0, loc)
+		sum, observed := weigh([]Change{{Time: tc.day, Value: 1}}, tc.day, end, loc)
+		total := 0.0
+		for hour := range observed[6] {
+			total += observed[6][hour]
+			want := 3600.0
+			if hour == 2 {
+				want = tc.two * 3600
+			}
+			if observed[6][hour] != want || sum[6][hour] != want {
+				t.Errorf("%s: %02d:00 sum %v observed %v, want %v", tc.name, hour, sum[6][hour], observed[6][hour], want)
+			}
+		}
+		if total != tc.hours*3600 {
+			t.Errorf("%s: observed %v hours, want %v", tc.name, total/3600, tc.hours)
+		}
+		for _, day := range []int{0, 5} {
+			for hour := range observed[day] {
+				if observed[day][hour] != 0 {
+					t.Errorf("%s: observed day %d %02d:00", tc.name, day, hour)
+				}
+			}
+		}
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	loc := berlin(t)
+	now := time.Date(2024, 6, 4, 0, 0, 0, 0, loc)
+	tracker := NewTracker(24 * time.Hour)
+	tracker.Status(now.Add(-30*time.Hour), 0)
+	tracker.Status(now.Add(-4*time.Hour), 1)
+	tracker.People(now.Add(-4*time.Hour), 3)
+	tracker.People(now.Add(-3*time.Hour), 3)
+	tracker.People(now.Add(-2*time.Hour), 1)
+	h := tracker.Heatmap(now, loc)
+	if len(h.Slots) != 7 || len(h.Slots[0]) != 24 {
+		t.Fatalf("invalid slots %dx%d", len(h.Slots), len(h.Slots[0]))
+	}
+	for hour, want := range map[int]Slot{
+		19: {Weekday: "Monday", Hour: 19, Open: 0, Observed: 1},
+		20: {Weekday: "Monday", Hour: 20, Open: 1, People: 3, Observed: 1},
+		22: {Weekday: "Monday", Hour: 22, Open: 1, People: 1, Observed: 1},
+	} {
+		if have := h.Slots[0][hour]; have != want {
+			t.Errorf("%02d:00: %+v, want %+v", hour, have, want)
+		}
+	}
+}
+
+func TestHeatmapApproximate(t *testing.T) {
+	loc := berlin(t)
+	now := time.Date(2024, 6, 4, 0, 0, 0, 0, loc)
+	tracker := NewTracker(24 * time.Hour)
+	tracker.Status(now.Add(-6*time.Hour), 0.5)
+	tracker.Status(now.Add(-4*time.Hour-30*time.Minute), 1)
+	tracker.Approximate(now.Add(-4 * time.Hour))
+	h := tracker.Heatmap(now, loc)
+	for hour, want := range map[int]float64{17: 0, 18: 1, 19: 1, 20: 0, 21: 0} {
+		if have := h.Slots[0][hour].Approximate; have != want {
+			t.Errorf("%02d:00: %v approximate hours, want %v", hour, have, want)
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	loc := berlin(t)
+	now := time.Date(2024, 6, 4, 0, 0, 0, 0, loc)
+	tracker := NewTracker(24 * time.Hour)
+	tracker.Status(now.Add(-6*time.Hour), 0.5)
+	tracker.Status(now.Add(-4*time.Hour), 1)
+	tracker.People(now.Add(-4*time.Hour), 3)
+	tracker.Approximate(now.Add(-4 * time.Hour))
+	buf := &bytes.Buffer{}
+	if err := tracker.Heatmap(now, loc).SVG(buf); err != nil {
+		t.Fatal(err)
+	}
+	var svg struct {
+		Texts []string `xml:"text"`
+		Rects []struct {
+			Opacity string `xml:"fill-opacity,attr"`
+			Title   string `xml:"title"`
+		} `xml:"rect"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &svg); err != nil {
+		t.Fatalf("invalid svg: %v\n%s", err, buf)
+	}
+	if len(svg.Texts) != 8+7 || svg.Texts[8] != "Mon" {
+		t.Errorf("invalid labels %q", svg.Texts)
+	}
+	if len(svg.Rects) != 2*7*24 {
+		t.Fatalf("got %d rects, want %d", len(svg.Rects), 2*7*24)
+	}
+	for hour, want := range map[int]struct{ opacity, title string }{
+		18: {"0.50", "Monday 18:00: 50% open, 0.0 people (1 of 1 hours approximate)"},
+		20: {"1.00", "Monday 20:00: 100% open, 3.0 people"},
+		21: {"1.00", "Monday 21:00: 100% open, 3.0 people"},
+	} {
+		rect := svg.Rects[2*hour+1]
+		if rect.Opacity != want.opacity || rect.Title != want.title {
+			t.Errorf("%02d:00: %s %q, want %s %q", hour, rect.Opacity, rect.Title, want.opacity, want.title)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"fmt"
+	"html"
+	"io"
+)
+
+const (
+	cell   = 20
+	left   = 40
+	top    = 20
+	width  = left + 24*cell
+	height = top + 7*cell
+)
+
+// SVG renders the heatmap, the opacity of each cell is the open probability
+func (h Heatmap) SVG(w io.Writer) error {
+	out := &svgWriter{w: w}
+	out.printf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="10">`+"\n", width, height, width, height)
+	for hour := 0; hour < 24; hour += 3 {
+		out.printf(`<text x="%d" y="%d" text-anchor="middle">%02d</text>`+"\n", left+hour*cell+cell/2, top-6, hour)
+	}
+	for day, slots := range h.Slots {
+		y := top + day*cell
+		out.printf(`<text x="%d" y="%d" text-anchor="end">%s</text>`+"\n", left-6, y+cell/2+4, slots[0].Weekday[:3])
+		for hour, slot := range slots {
+			x := left + hour*cell
+			title := fmt.Sprintf("%s %02d:00: %.0f%% open, %.1f people", slot.Weekday, slot.Hour, slot.Open*100, slot.People)
+			if slot.Approximate > 0 {
+				title += fmt.Sprintf(" (%.0f of %.0f hours approximate)", slot.Approximate, slot.Observed)
+			}
+			out.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="#eeeeee"/>`+"\n", x, y, cell-1, cell-1)
+			out.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="#2e7d32" fill-opacity="%.2f"><title>%s</title></rect>`+"\n",
+				x, y, cell-1, cell-1, slot.Open, html.EscapeString(title))
+		}
+	}
+	out.printf("</svg>\n")
+	return out.err
+}
+
+// svgWriter keeps the first write error
+type svgWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *svgWriter) printf(format string, args ...interface{}) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.w, format, args...)
+	}
+}
@@ -0,0 +1,99 @@
+package topic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Valid checks if filter is a valid MQTT topic filter
+func Valid(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("empty topic filter")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return fmt.Errorf("invalid topic filter %q: '#' must be the last level", filter)
+		case level != "#" && strings.Contains(level, "#"):
+			return fmt.Errorf("invalid topic filter %q: '#' must occupy a whole level", filter)
+		case level != "+" && strings.Contains(level, "+"):
+			return fmt.Errorf("invalid topic filter %q: '+' must occupy a whole level", filter)
+		}
+	}
+	return nil
+}
+
+// Match reports whether topic matches filter using MQTT wildcard semantics
+func Match(filter, topic string) bool {
+	_, ok := Capture(filter, topic)
+	return ok
+}
+
+// Capture matches topic against filter and returns the topic levels
+// matched by the wildcards. A '#' captures the remaining levels joined by '/'.
+func Capture(filter, topic string) (segments []string, ok bool) {
+	// topics starting with '$' are not matched by leading wildcards
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return nil, false
+	}
+	filters := strings.Split(filter, "/")
+	topics := strings.Split(topic, "/")
+	segments = []string{}
+	for i, f := range filters {
+		if f == "#" && i <= len(topics) {
+			// "a/#" also matches the parent level "a"
+			return append(segments, strings.Join(topics[i:], "/")), true
+		}
+		if i >= len(topics) {
+			return nil, false
+		}
+		switch f {
+		case "+":
+			segments = append(segments, topics[i])
+		case topics[i]:
+		default:
+			return nil, false
+		}
+	}
+	if len(filters) != len(topics) {
+		return nil, false
+	}
+	return segments, true
+}
+
+// MatchAny reports whether topic matches any of filters
+func MatchAny(filters []string, topic string) bool {
+	for _, filter := range filters {
+		if Match(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether every topic matched by filter inner is also
+// matched by filter outer
+func Covers(outer, inner string) bool {
+	if strings.HasPrefix(inner, "$") && (strings.HasPrefix(outer, "+") || strings.HasPrefix(outer, "#")) {
+		return false
+	}
+	outers := strings.Split(outer, "/")
+	inners := strings.Split(inner, "/")
+	for i, o := range outers {
+		if o == "#" {
+			return true
+		}
+		if i >= len(inners) {
+			return false
+		}
+		switch {
+		case inners[i] == "#":
+			return false
+		case o == "+":
+		case o != inners[i] || inners[i] == "+":
+			return false
+		}
+	}
+	return len(outers) == len(inners)
+}
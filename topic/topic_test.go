@@ -0,0 +1,89 @@
+package topic
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"#", "sensor/space/status", true},
+		{"sensor/#", "sensor", true},
+		{"sensor/#", "sensor/space/status", true},
+		{"sensor/+/status", "sensor/space/status", true},
+		{"sensor/+/status", "sensor/space/member/status", false},
+		{"sensor/+", "sensor/space/status", false},
+		{"sensor/space/status", "sensor/space/status", true},
+		{"sensor/space/status", "sensor/space", false},
+		{"sensor/space", "sensor/space/status", false},
+		{"+/+", "/status", true},
+		{"#", "$SYS/broker/uptime", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+	} {
+		if have := Match(tc.filter, tc.topic); have != tc.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tc.filter, tc.topic, have, tc.want)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	have, ok := Capture("sensor/temperature/+/+", "sensor/temperature/hackcenter/shelf")
+	if !ok {
+		t.Fatalf("expected match")
+	}
+	if diff := cmp.Diff([]string{"hackcenter", "shelf"}, have); diff != "" {
+		t.Errorf("Invalid segments. \n%s", diff)
+	}
+	have, ok = Capture("sensor/#", "sensor/power/main/L1")
+	if !ok {
+		t.Fatalf("expected match")
+	}
+	if diff := cmp.Diff([]string{"power/main/L1"}, have); diff != "" {
+		t.Errorf("Invalid segments. \n%s", diff)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for filter, valid := range map[string]bool{
+		"#":                   true,
+		"sensor/+/status":     true,
+		"sensor/space/status": true,
+		"":                    false,
+		"sensor/#/status":     false,
+		"sensor/sp#":          false,
+		"sensor/sp+ce":        false,
+	} {
+		if err := Valid(filter); (err == nil) != valid {
+			t.Errorf("Valid(%q) = %v, want valid %v", filter, err, valid)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	for _, tc := range []struct {
+		outer string
+		inner string
+		want  bool
+	}{
+		{"#", "sensor/#", true},
+		{"sensor/#", "sensor", true},
+		{"sensor/#", "sensor/+/status", true},
+		{"sensor/+/status", "sensor/space/status", true},
+		{"sensor/+/status", "sensor/+/status", true},
+		{"sensor/+", "sensor/#", false},
+		{"sensor/space/status", "sensor/+/status", false},
+		{"sensor/+/status", "sensor/space", false},
+		{"sensor", "sensor/#", false},
+		{"#", "$SYS/broker/uptime", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+	} {
+		if have := Covers(tc.outer, tc.inner); have != tc.want {
+			t.Errorf("Covers(%q, %q) = %v, want %v", tc.outer, tc.inner, have, tc.want)
+		}
+	}
+}
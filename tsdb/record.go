@@ -0,0 +1,118 @@
+package tsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+// Point aggregates the samples of a topic within a bucket. Raw samples
+// are stored as points with a count of one.
+type Point struct {
+	Topic string    `json:"-"`
+	Start time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Sum   float64   `json:"sum"`
+	Count uint32    `json:"count"`
+}
+
+// Avg returns the mean of the aggregated samples
+func (p Point) Avg() float64 {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.Sum / float64(p.Count)
+}
+
+// add merges a sample into p
+func (p *Point) add(value float64) {
+	if p.Count == 0 || value < p.Min {
+		p.Min = value
+	}
+	if p.Count == 0 || value > p.Max {
+		p.Max = value
+	}
+	p.Sum += value
+	p.Count++
+}
+
+// records are framed as length, crc32 of the payload and the payload:
+// topic length, topic, start (unix nanoseconds), min, max, sum and count
+const headerSize = 8
+
+var errCorrupt = errors.New("corrupt record")
+
+func encode(p Point) []byte {
+	size := 2 + len(p.Topic) + 8 + 3*8 + 4
+	buf := make([]byte, headerSize+size)
+	payload := buf[headerSize:]
+	binary.LittleEndian.PutUint16(payload, uint16(len(p.Topic)))
+	n := 2 + copy(payload[2:], p.Topic)
+	binary.LittleEndian.PutUint64(payload[n:], uint64(p.Start.UnixNano()))
+	binary.LittleEndian.PutUint64(payload[n+8:], math.Float64bits(p.Min))
+	binary.LittleEndian.PutUint64(payload[n+16:], math.Float64bits(p.Max))
+	binary.LittleEndian.PutUint64(payload[n+24:], math.Float64bits(p.Sum))
+	binary.LittleEndian.PutUint32(payload[n+32:], p.Count)
+	binary.LittleEndian.PutUint32(buf, uint32(size))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	return buf
+}
+
+func decode(payload []byte) (p Point, err error) {
+	if len(payload) < 2 {
+		return p, errCorrupt
+	}
+	n := 2 + int(binary.LittleEndian.Uint16(payload))
+	if len(payload) != n+8+3*8+4 {
+		return p, errCorrupt
+	}
+	p.Topic = string(payload[2:n])
+	p.Start = time.Unix(0, int64(binary.LittleEndian.Uint64(payload[n:])))
+	p.Min = math.Float64frombits(binary.LittleEndian.Uint64(payload[n+8:]))
+	p.Max = math.Float64frombits(binary.LittleEndian.Uint64(payload[n+16:]))
+	p.Sum = math.Float64frombits(binary.LittleEndian.Uint64(payload[n+24:]))
+	p.Count = binary.LittleEndian.Uint32(payload[n+32:])
+	return p, nil
+}
+
+// scan calls f for each record in r. It returns the length of the valid
+// prefix, a torn or corrupt tail is not an error.
+func scan(r io.Reader, f func(Point)) (valid int64, err error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, headerSize)
+	for {
+		_, err = io.ReadFull(br, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+		size := binary.LittleEndian.Uint32(header)
+		if size > 1<<20 {
+			return valid, nil
+		}
+		payload := make([]byte, size)
+		_, err = io.ReadFull(br, payload)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+			return valid, nil
+		}
+		p, err := decode(payload)
+		if err != nil {
+			return valid, nil
+		}
+		f(p)
+		valid += int64(headerSize + len(payload))
+	}
+}
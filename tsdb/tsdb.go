@@ -0,0 +1,317 @@
+package tsdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options configure the storage tiers of a DB
+type Options struct {
+	Dir string
+	// RawInterval is the minimum time between two raw samples of a topic
+	RawInterval     time.Duration
+	RawRetention    time.Duration
+	HourlyRetention time.Duration
+	DailyRetention  time.Duration
+}
+
+// DB is an append-only time series store. Samples are written to a raw
+// tier and rolled up into hourly and daily aggregates, each tier is a
+// file of checksummed records that is compacted by retention.
+type DB struct {
+	lock    sync.Mutex
+	opts    Options
+	raw     *tier
+	tiers   []*tier
+	lastRaw map[string]time.Time
+}
+
+// tier is a file of points with a fixed bucket resolution, zero for raw samples
+type tier struct {
+	name       string
+	resolution time.Duration
+	retention  time.Duration
+	path       string
+	file       *os.File
+	// open holds the unflushed bucket per topic of aggregate tiers
+	open map[string]*Point
+}
+
+// Open opens or creates the DB in opts.Dir, a torn tail left by a crash
+// is truncated and unflushed aggregates are rebuilt from the raw tier.
+// Raw samples are thinned by RawInterval, so after a restart the open
+// hourly and daily buckets only contain the retained raw samples and
+// their count, min, max and average may differ from an uninterrupted run.
+func Open(opts Options) (*DB, error) {
+	if opts.RawRetention < 24*time.Hour {
+		return nil, fmt.Errorf("raw retention must be at least 24h to rebuild daily aggregates")
+	}
+	err := os.MkdirAll(opts.Dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		opts:    opts,
+		lastRaw: map[string]time.Time{},
+	}
+	db.raw = &tier{name: "raw", retention: opts.RawRetention}
+	db.tiers = []*tier{
+		db.raw,
+		{name: "hourly", resolution: time.Hour, retention: opts.HourlyRetention},
+		{name: "daily", resolution: 24 * time.Hour, retention: opts.DailyRetention},
+	}
+	// last flushed bucket per topic of the aggregate tiers
+	flushed := map[*tier]map[string]time.Time{}
+	for _, t := range db.tiers {
+		t.path = filepath.Join(opts.Dir, t.name+".log")
+		t.open = map[string]*Point{}
+		last := map[string]time.Time{}
+		flushed[t] = last
+		err = t.recover(func(p Point) {
+			if p.Start.After(last[p.Topic]) {
+				last[p.Topic] = p.Start
+			}
+		})
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	for topic, start := range flushed[db.raw] {
+		db.lastRaw[topic] = start
+	}
+	// replay raw samples newer than the last flushed bucket
+	var writeErr error
+	err = db.raw.read(func(p Point) {
+		for _, t := range db.tiers[1:] {
+			if last, found := flushed[t][p.Topic]; found && p.Start.Before(last.Add(t.resolution)) {
+				continue
+			}
+			if flush := t.merge(p); flush != nil && writeErr == nil {
+				writeErr = t.append(*flush)
+			}
+		}
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// recover truncates a torn tail and opens the tier file for appending
+func (t *tier) recover(f func(Point)) error {
+	file, err := os.OpenFile(t.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	valid, err := scan(file, f)
+	if err == nil {
+		err = file.Truncate(valid)
+	}
+	if err == nil {
+		_, err = file.Seek(valid, 0)
+	}
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to recover %s: %w", t.path, err)
+	}
+	t.file = file
+	return nil
+}
+
+// read calls f for all points stored in the tier file
+func (t *tier) read(f func(Point)) error {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = scan(file, f)
+	return err
+}
+
+func (t *tier) append(p Point) error {
+	_, err := t.file.Write(encode(p))
+	return err
+}
+
+// merge adds a raw sample to its open bucket, the previous bucket is
+// returned if it has to be flushed. Samples older than the open bucket
+// are ignored.
+func (t *tier) merge(sample Point) (flush *Point) {
+	start := sample.Start.Truncate(t.resolution)
+	open, found := t.open[sample.Topic]
+	if found && start.Before(open.Start) {
+		return nil
+	}
+	if !found || start.After(open.Start) {
+		flush = open
+		open = &Point{Topic: sample.Topic, Start: start}
+		t.open[sample.Topic] = open
+	}
+	open.add(sample.Sum)
+	return flush
+}
+
+// Add stores a sample
+func (db *DB) Add(topic string, ts time.Time, value float64) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	sample := Point{Topic: topic, Start: ts}
+	sample.add(value)
+	for _, t := range db.tiers[1:] {
+		if flush := t.merge(sample); flush != nil {
+			err := t.append(*flush)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if last, found := db.lastRaw[topic]; found && ts.Sub(last) < db.opts.RawInterval {
+		return nil
+	}
+	db.lastRaw[topic] = ts
+	return db.raw.append(sample)
+}
+
+// Query returns the points of topic in [from, to] from the finest tier
+// whose retention covers from, including unflushed aggregates. The tier
+// file is read without holding the lock, so Add is not blocked.
+func (db *DB) Query(topic string, from, to time.Time) ([]Point, error) {
+	db.lock.Lock()
+	t := db.tierFor(from)
+	var open *Point
+	if p, found := t.open[topic]; found {
+		snapshot := *p
+		open = &snapshot
+	}
+	db.lock.Unlock()
+	points := []Point{}
+	err := t.read(func(p Point) {
+		if p.Topic != topic || p.Start.Before(from) || p.Start.After(to) {
+			return
+		}
+		// the bucket was flushed after the snapshot, the flushed one is complete
+		if open != nil && p.Start.Equal(open.Start) {
+			open = nil
+		}
+		points = append(points, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if open != nil && !open.Start.Before(from) && !open.Start.After(to) {
+		points = append(points, *open)
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Start.Before(points[j].Start)
+	})
+	return points, nil
+}
+
+// Resolution returns the bucket size of the tier queried for from
+func (db *DB) Resolution(from time.Time) time.Duration {
+	return db.tierFor(from).resolution
+}
+
+func (db *DB) tierFor(from time.Time) *tier {
+	now := time.Now()
+	for _, t := range db.tiers {
+		if !from.Before(now.Add(-t.retention)) {
+			return t
+		}
+	}
+	return db.tiers[len(db.tiers)-1]
+}
+
+// Compact rewrites the tier files without points older than their retention
+func (db *DB) Compact() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	now := time.Now()
+	for _, t := range db.tiers {
+		err := t.compact(now.Add(-t.retention))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tier) compact(oldest time.Time) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(t.path), t.name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	var writeErr error
+	err = t.read(func(p Point) {
+		if writeErr == nil && !p.Start.Before(oldest) {
+			_, writeErr = tmp.Write(encode(p))
+		}
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), t.path)
+	if err != nil {
+		return err
+	}
+	_ = t.file.Close()
+	t.file = tmp
+	return nil
+}
+
+// Sync flushes the tier files to disk
+func (db *DB) Sync() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	for _, t := range db.tiers {
+		if err := t.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the tier files, open aggregates are rebuilt
+// from the raw tier on the next Open
+func (db *DB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var err error
+	for _, t := range db.tiers {
+		if t.file == nil {
+			continue
+		}
+		if e := t.file.Sync(); e != nil && err == nil {
+			err = e
+		}
+		if e := t.file.Close(); e != nil && err == nil {
+			err = e
+		}
+		t.file = nil
+	}
+	return err
+}
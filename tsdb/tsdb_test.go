@@ -0,0 +1,142 @@
+package tsdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testOptions(t *testing.T) Options {
+	return Options{
+		Dir:             t.TempDir(),
+		RawRetention:    48 * time.Hour,
+		HourlyRetention: 90 * 24 * time.Hour,
+		DailyRetention:  365 * 24 * time.Hour,
+	}
+}
+
+func TestRollup(t *testing.T) {
+	opts := testOptions(t)
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+	hour := time.Now().Add(-3 * time.Hour).Truncate(time.Hour)
+	for i, v := range []float64{1, 3, 5} {
+		_ = db.Add("sensor/power/main/total", hour.Add(time.Duration(i)*time.Minute), v)
+	}
+	_ = db.Add("sensor/power/main/total", hour.Add(time.Hour), 7)
+
+	raw, _ := db.Query("sensor/power/main/total", hour, time.Now())
+	if len(raw) != 4 {
+		t.Errorf("got %d raw points, want 4", len(raw))
+	}
+
+	// force the hourly tier by querying beyond the raw retention
+	points, err := db.Query("sensor/power/main/total", time.Now().Add(-50*time.Hour), time.Now())
+	if err != nil {
+		t.Fatalf("unable to query: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("got %d hourly points, want 2", len(points))
+	}
+	if p := points[0]; !p.Start.Equal(hour) || p.Min != 1 || p.Max != 5 || p.Avg() != 3 || p.Count != 3 {
+		t.Errorf("invalid hourly rollup %+v", p)
+	}
+	if p := points[1]; p.Count != 1 || p.Sum != 7 {
+		t.Errorf("invalid open bucket %+v", p)
+	}
+	_ = db.Close()
+}
+
+func TestQueryConcurrent(t *testing.T) {
+	db, err := Open(testOptions(t))
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+	defer db.Close()
+	start := time.Now().Add(-10 * time.Hour).Truncate(time.Hour)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 600; i++ {
+			_ = db.Add("sensor/power/main/total", start.Add(time.Duration(i)*time.Minute), 1)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		points, err := db.Query("sensor/power/main/total", time.Now().Add(-50*time.Hour), time.Now())
+		if err != nil {
+			t.Fatalf("unable to query: %v", err)
+		}
+		for i := 1; i < len(points); i++ {
+			if !points[i].Start.After(points[i-1].Start) {
+				t.Fatalf("duplicate bucket %v", points[i].Start)
+			}
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	opts := testOptions(t)
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+	start := time.Now().Add(-time.Hour)
+	_ = db.Add("sensor/space/status", start, 1)
+	_ = db.Add("sensor/space/status", start.Add(time.Minute), 0)
+	_ = db.Close()
+
+	// simulate a torn write
+	raw := filepath.Join(opts.Dir, "raw.log")
+	f, _ := os.OpenFile(raw, os.O_WRONLY|os.O_APPEND, 0)
+	_, _ = f.Write(encode(Point{Topic: "sensor/space/status", Start: start, Count: 1})[:10])
+	_ = f.Close()
+
+	db, err = Open(opts)
+	if err != nil {
+		t.Fatalf("unable to reopen: %v", err)
+	}
+	defer db.Close()
+	_ = db.Add("sensor/space/status", start.Add(2*time.Minute), 1)
+	points, _ := db.Query("sensor/space/status", start, time.Now())
+	if len(points) != 3 {
+		t.Fatalf("got %d points after recovery, want 3", len(points))
+	}
+
+	// unflushed aggregates are rebuilt from the raw tier
+	hourly, _ := db.Query("sensor/space/status", time.Now().Add(-50*time.Hour), time.Now())
+	count := uint32(0)
+	for _, p := range hourly {
+		count += p.Count
+	}
+	if count != 3 {
+		t.Errorf("hourly aggregates cover %d samples, want 3", count)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	opts := testOptions(t)
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+	defer db.Close()
+	now := time.Now()
+	_ = db.Add("sensor/radiation/cpm", now.Add(-72*time.Hour), 40)
+	_ = db.Add("sensor/radiation/cpm", now.Add(-time.Minute), 42)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("unable to compact: %v", err)
+	}
+	_ = db.Add("sensor/radiation/cpm", now, 44)
+	points, _ := db.Query("sensor/radiation/cpm", now.Add(-47*time.Hour), now)
+	if len(points) != 2 || points[0].Sum != 42 || points[1].Sum != 44 {
+		t.Errorf("invalid points after compaction %+v", points)
+	}
+}